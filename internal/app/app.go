@@ -3,23 +3,30 @@ package app
 import (
     "context"
     "errors"
-    "log"
+    "log/slog"
     "net/http"
     "os"
     "os/signal"
+    "sync"
+    "sync/atomic"
     "syscall"
+    "time"
 
     "github.com/go-playground/validator/v10"
-    "github.com/goccy/go-json"
     amqp "github.com/rabbitmq/amqp091-go"
     "github.com/yemyoaung/managing-vehicle-tracking-common"
     "github.com/yemyoaung/managing-vehicle-tracking-models"
     "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/config"
     "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/handler"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/logging"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/metrics"
     "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
     "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/tracing"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/validation"
     "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
+    "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 var (
@@ -27,12 +34,33 @@ var (
 )
 
 type App struct {
-    validator  *validator.Validate
-    cfg        *config.EnvConfig
-    db         *mongo.Client
-    rabbitConn *common.RabbitConnection
-    shutdown   chan error
-    exit       chan os.Signal
+    validator   *validator.Validate
+    cfg         *config.EnvConfig
+    db          *mongo.Client
+    rabbitConn  *common.RabbitConnection
+    publishPool *repositories.RabbitChannelPool
+    outboxRelay *outboxRelay
+    server      *http.Server
+    shutdown    chan error
+    exit        chan os.Signal
+
+    // cancel stops the consumer and the outbox relay
+    cancel context.CancelFunc
+    // starting is done once Run has started everything (or gave up), so Shutdown doesn't race with it,
+    // Run only adds to it while stopping isn't set, so Shutdown doesn't wait for a Run which was never called
+    starting sync.WaitGroup
+    // startMu guards stopping, which is set by Shutdown so a Run called after it doesn't start anything
+    startMu  sync.Mutex
+    stopping bool
+    // workers are the consumer and the outbox relay
+    workers sync.WaitGroup
+
+    // consumerChannel is the channel the consumer was last started on
+    consumerChannel atomic.Pointer[amqp.Channel]
+    // consuming is set while the broker delivers tracking messages to the consumer
+    consuming atomic.Bool
+    // shutdownTracing flushes the spans which weren't exported yet
+    shutdownTracing func(context.Context) error
 }
 
 // NewApp creates a new App instance
@@ -59,95 +87,44 @@ func (a *App) SetValidator(validator *validator.Validate) *App {
 
 // SetConfig sets the configuration for the application
 func (a *App) SetConfig(cfg *config.EnvConfig) *App {
+    if cfg != nil {
+        cfg.SetDefaults()
+    }
     a.cfg = cfg
     return a
 }
 
-// Consume listens for messages from RabbitMQ and processes them
-func (a *App) Consume(
-    vehicleService services.VehicleService,
-    channel *amqp.Channel,
-) {
-    // Declare the tracking queue with durable
-    _, err := channel.QueueDeclare(
-        a.cfg.VehicleQueue,
-        true,
-        false,
-        false,
-        false,
-        nil,
-    )
-    if err != nil {
-        a.shutdown <- err
+// Run starts the app, connects to MongoDB, RabbitMQ, starts the HTTP server and consumes tracking data messages
+func (a *App) Run(ctx context.Context) {
+    a.startMu.Lock()
+    if a.stopping {
+        a.startMu.Unlock()
         return
     }
+    a.starting.Add(1)
+    a.startMu.Unlock()
+    defer a.starting.Done()
 
-    // Start consuming messages from the declared queue
-    trackingDataMessages, err := channel.Consume(
-        a.cfg.VehicleQueue,
-        "",
-        false,
-        false,
-        false,
-        false,
-        nil,
-    )
+    var err error
+    if a.cfg == nil {
+        a.shutdown <- ErrConfigMissing
+        return
+    }
+
+    // Set up logging, the records logged with a context carry its request and message IDs
+    logger, err := logging.NewLogger(os.Stdout, a.cfg.LogLevel, a.cfg.LogFormat)
     if err != nil {
         a.shutdown <- err
         return
     }
+    slog.SetDefault(logger)
 
-    go func(
-        trackingDataMessages <-chan amqp.Delivery,
-        channel *amqp.Channel,
-        vehicleService services.VehicleService,
-    ) {
-        for msg := range trackingDataMessages {
-            go func(msg amqp.Delivery, channel *amqp.Channel) {
-                var trackingData models.TrackingDataRequest
-                if err := json.Unmarshal(msg.Body, &trackingData); err != nil {
-                    log.Printf("Failed to unmarshal message: %v", err)
-                    // Nack the message on error
-                    err := msg.Nack(false, false)
-                    if err != nil {
-                        log.Println("Failed to nack message: ", err)
-                        return
-                    }
-                    return
-                }
-                log.Println("Received tracking data: ", trackingData)
-
-                // Update vehicle mileage using vehicle service 
-                if err := vehicleService.TrackingVehicle(
-                    context.Background(),
-                    trackingData.VehicleID,
-                    trackingData.Mileage,
-                    trackingData.Status,
-                ); err != nil {
-                    log.Println("Failed to track vehicle: ", err)
-                    err := msg.Nack(false, false)
-                    if err != nil {
-                        log.Println("Failed to nack message: ", err)
-                        return
-                    }
-                    return
-                }
-
-                // Acknowledge the message after processing
-                if err := msg.Ack(false); err != nil {
-                    log.Println("Failed to ack message: ", err)
-                    return
-                }
-            }(msg, channel)
-        }
-    }(trackingDataMessages, channel, vehicleService)
-}
+    ctx, a.cancel = context.WithCancel(ctx)
 
-// Run starts the app, connects to MongoDB, RabbitMQ, starts the HTTP server and consumes tracking data messages
-func (a *App) Run(ctx context.Context) {
-    var err error
-    if a.cfg == nil {
-        a.shutdown <- ErrConfigMissing
+    // Set up tracing, the trace context is propagated from the HTTP requests through the outbox to the consumer
+    a.shutdownTracing, err = tracing.Setup(ctx, a.cfg.TracingExporter, a.cfg.TracingEndpoint)
+    if err != nil {
+        a.shutdown <- err
         return
     }
 
@@ -180,87 +157,245 @@ func (a *App) Run(ctx context.Context) {
         a.shutdown <- err
         return
     }
+    vehicleRepos.SetMileageTolerance(a.cfg.MileageTolerance).
+        SetExactCountLimit(int64(*a.cfg.ExactCountLimit)).
+        SetCursorKey(a.cfg.SignatureKey)
+
+    // Initialize the tracking history repository, it stores every tracking message in a time-series collection
+    historyRepo, err := repositories.NewMongoTrackingHistoryRepository(ctx, a.db.Database("vehicles"))
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
 
-    // Set up RabbitMQ connection
+    // Initialize the processed message repository, it lets the consumer skip redelivered messages
+    processedRepo, err := repositories.NewMongoProcessedMessageRepository(
+        ctx,
+        a.db.Database("vehicles"),
+        time.Duration(a.cfg.MessageDedupTTLSeconds)*time.Second,
+    )
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Set up RabbitMQ connection, it only carries the consumer's channel,
+    // which is opened (and re-opened after a broker restart) by superviseRabbit
     a.rabbitConn = common.NewRabbitConnection(a.cfg.RabbitmqUrl)
 
-    channel, err := a.rabbitConn.Channel()
+    // Tracking data is published through a pool of channels on a separate connection
+    a.publishPool = repositories.NewRabbitChannelPool(a.cfg.RabbitmqUrl, a.cfg.PublisherChannels)
+    trackingRepo := repositories.NewRabbitMqTrackingRepository(a.publishPool, a.cfg.TrackingQueue).
+        SetConfirmTimeout(time.Duration(a.cfg.PublishConfirmTimeoutMs) * time.Millisecond)
+
+    // Initialize the outbox repository, tracking data is stored there first and published by the outbox relay
+    outboxRepo, err := repositories.NewMongoOutboxRepository(ctx, a.db.Database("vehicles"))
     if err != nil {
         a.shutdown <- err
         return
     }
 
-    trackingRepo := repositories.NewRabbitMqTrackingRepository(channel, a.cfg.TrackingQueue)
+    a.outboxRelay = newOutboxRelay(
+        outboxRepo,
+        trackingRepo,
+        time.Duration(a.cfg.OutboxPollIntervalMs)*time.Millisecond,
+        a.cfg.OutboxMaxAttempts,
+    )
+    metrics.RegisterOutboxBacklog(
+        func() float64 {
+            return float64(a.outboxRelay.Backlog())
+        },
+    )
+    a.workers.Add(1)
+    go func() {
+        defer a.workers.Done()
+        a.outboxRelay.Run(ctx)
+    }()
 
-    vehicleService := services.NewMongoVehicleService(vehicleRepos, trackingRepo)
-    vehicleHandler := handler.NewV1VehicleHandler(vehicleService, a.validator)
+    vehicleService := services.NewMongoVehicleService(vehicleRepos, outboxRepo, historyRepo, processedRepo)
+    // Set up the translations of the validation errors, the invalid fields are reported by their JSON name
+    translator, err := validation.NewTranslator(a.validator)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+    vehicleHandler := handler.NewV1VehicleHandler(vehicleService, translator)
 
-    go a.Consume(vehicleService, channel)
+    a.workers.Add(1)
+    go func() {
+        defer a.workers.Done()
+        a.superviseRabbit(ctx, vehicleService)
+    }()
 
     // Set up the HTTP server
     server := http.NewServeMux()
 
+    // Set up the probes, they are outside the v1 router, so the orchestrator can call them without credentials
+    healthHandler := handler.NewV1HealthHandler().
+        AddCheck("mongo", a.pingMongo).
+        AddCheck("rabbitmq", a.checkRabbit).
+        AddCheck("consumer", a.checkConsumer)
+    server.HandleFunc("/healthz", healthHandler.Liveness) // Liveness probe
+    server.HandleFunc("/readyz", healthHandler.Readiness) // Readiness probe
+    server.Handle("/metrics", metrics.Handler())          // Prometheus metrics
+
     // Set up the API routes
-    v1Router := http.NewServeMux()                                                     // API version 1 router
-    v1Router.HandleFunc("/api/v1/vehicles", vehicleHandler.HandleCreateAndFindVehicle) // Vehicle creation and find
-    v1Router.HandleFunc("/api/v1/vehicles/", vehicleHandler.FindVehicleByID)           // Find vehicle by ID
-    v1Router.HandleFunc("/api/v1/tracking", vehicleHandler.PublishTrackingData)        // Publish tracking data
+    // Every route records its request durations and spans, the routes are used as labels and span names
+    // instead of the paths, so the vehicle IDs don't end up in the metrics
+    v1Router := http.NewServeMux() // API version 1 router
+    // Vehicle creation and find
+    v1Router.Handle(
+        "/api/v1/vehicles",
+        instrumentRoute("/api/v1/vehicles", vehicleHandler.HandleCreateAndFindVehicle),
+    )
+    // Find, update and delete vehicle by ID
+    v1Router.Handle(
+        "/api/v1/vehicles/",
+        instrumentRoute("/api/v1/vehicles/{id}", vehicleHandler.HandleVehicleByID),
+    )
+    // Publish tracking data
+    v1Router.Handle(
+        "/api/v1/tracking",
+        instrumentRoute("/api/v1/tracking", vehicleHandler.PublishTrackingData),
+    )
 
     // Apply middlewares and handle requests
     // The v1Router (which holds our API routes) will have two middlewares applied:
+    // - RequestIDMiddleware: Gives each request an ID, which is echoed in the response and passed to the services
     // - CorsMiddleware: Adds CORS headers to the response
-    // - LoggingMiddleware: Logs each incoming request for debugging and monitoring
+    // - AccessLogMiddleware: Logs each incoming request for debugging and monitoring
     // - AuthorizationMiddleware: Authorizes the request using the auth service
     // - VerifySignatureMiddleware: Verifies the request's signature (ensuring it's from a trusted source)
     server.Handle(
         "/",
-        common.CorsMiddleware(nil)(
-            common.LoggingMiddleware(log.Default())(
-                common.AuthorizationMiddleware[models.AuthUser](a.cfg.AuthSvc, a.cfg.SignatureKey)(
-                    common.VerifySignatureMiddleware(a.cfg.SignatureKey)(
-                        v1Router,
+        logging.RequestIDMiddleware(
+            common.CorsMiddleware(
+                &common.CorsConfig{
+                    AllowedOrigins: "*",
+                    AllowedMethods: "GET, POST, PUT, PATCH, DELETE, OPTIONS",
+                    AllowedHeaders: "*",
+                },
+            )(
+                logging.AccessLogMiddleware(logger)(
+                    common.AuthorizationMiddleware[models.AuthUser](a.cfg.AuthSvc, a.cfg.SignatureKey)(
+                        common.VerifySignatureMiddleware(a.cfg.SignatureKey)(
+                            v1Router,
+                        ),
                     ),
                 ),
             ),
         ),
     )
 
-    log.Println("Vehicle service started on Port: ", a.cfg.Port)
+    slog.Info("Vehicle service started", slog.String("host", a.cfg.Host), slog.String("port", a.cfg.Port))
+
+    a.server = &http.Server{
+        Addr:    a.cfg.Host + ":" + a.cfg.Port,
+        Handler: server,
+    }
 
     // Start the HTTP server in a goroutine
     go func() {
-        err = http.ListenAndServe(a.cfg.Host+":"+a.cfg.Port, server)
+        err := a.server.ListenAndServe()
         if !errors.Is(err, http.ErrServerClosed) {
             a.shutdown <- err
         }
     }()
 }
 
-// Shutdown gracefully shuts down the app
+// Shutdown waits for a termination signal (or a failure) and gracefully shuts down the app,
+// the HTTP server stops accepting requests, the consumer is cancelled, and the in-flight requests
+// and tracking messages are waited for (at most ShutdownTimeoutMs) before the connections are closed
 func (a *App) Shutdown(ctx context.Context) error {
     defer close(a.shutdown)
 
-    // Disconnect from MongoDB client
-    defer func(ctx context.Context, client *mongo.Client) {
-        if client == nil {
-            return
+    shutdownErr := <-a.shutdown
+
+    // wait until Run has started everything, so nothing is started after it was stopped,
+    // a Run which wasn't called yet won't start at all
+    a.startMu.Lock()
+    a.stopping = true
+    a.startMu.Unlock()
+    a.starting.Wait()
+
+    if a.cfg != nil {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, time.Duration(a.cfg.ShutdownTimeoutMs)*time.Millisecond)
+        defer cancel()
+    }
+
+    // Stop accepting requests and wait for the in-flight ones
+    if a.server != nil {
+        if err := a.server.Shutdown(ctx); err != nil {
+            slog.Error("Failed to shut down the HTTP server", slog.Any("error", err))
         }
-        err := client.Disconnect(ctx)
-        if err != nil {
-            log.Println("Failed to disconnect from database", err)
+    }
+
+    // Cancel the consumer and the outbox relay, and wait until the delivered messages are acked or requeued
+    if a.cancel != nil {
+        a.cancel()
+    }
+    if err := a.waitWorkers(ctx); err != nil {
+        slog.Warn("Stopped waiting for the consumer", slog.Any("error", err))
+    }
+
+    // Flush the spans of the requests and the tracking messages
+    if a.shutdownTracing != nil {
+        if err := a.shutdownTracing(ctx); err != nil {
+            slog.Error("Failed to flush the spans", slog.Any("error", err))
         }
-    }(ctx, a.db)
+    }
+
+    // Close RabbitMQ connection, the unacked messages go back to the queue
+    if a.rabbitConn != nil {
+        if err := a.rabbitConn.Close(); err != nil {
+            slog.Error("Failed to close RabbitMQ connection", slog.Any("error", err))
+        }
+    }
 
-    // Close RabbitMQ connection
-    defer func(conn *common.RabbitConnection) {
-        if conn == nil {
-            return
+    // Close the connection of the publishing channels
+    if a.publishPool != nil {
+        if err := a.publishPool.Close(); err != nil {
+            slog.Error("Failed to close RabbitMQ publisher connection", slog.Any("error", err))
         }
-        err := conn.Close()
-        if err != nil {
-            log.Println("Failed to close RabbitMQ connection", err)
+    }
+
+    // Disconnect from MongoDB client
+    if a.db != nil {
+        if err := a.db.Disconnect(ctx); err != nil {
+            slog.Error("Failed to disconnect from database", slog.Any("error", err))
         }
-    }(a.rabbitConn)
+    }
 
-    return <-a.shutdown
+    return shutdownErr
+}
+
+// instrumentRoute records the metrics and the spans of the route's requests,
+// the span continues the trace context of the incoming request (if any)
+func instrumentRoute(route string, handler http.HandlerFunc) http.Handler {
+    return otelhttp.NewHandler(
+        metrics.InstrumentRoute(route, handler),
+        route,
+        otelhttp.WithSpanNameFormatter(
+            func(operation string, r *http.Request) string {
+                return r.Method + " " + operation
+            },
+        ),
+    )
+}
+
+// waitWorkers waits until the consumer and the outbox relay returned or the context is done
+func (a *App) waitWorkers(ctx context.Context) error {
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        a.workers.Wait()
+    }()
+
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
 }
@@ -0,0 +1,29 @@
+package app
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestApp_ShutdownWithoutRun(t *testing.T) {
+    a := NewApp()
+
+    done := make(chan error, 1)
+    go func() {
+        done <- a.Shutdown(context.Background())
+    }()
+    a.shutdown <- nil
+
+    select {
+    case err := <-done:
+        if err != nil {
+            t.Fatal(err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("Shutdown should not wait for a Run which was never called")
+    }
+
+    // Run doesn't start anything once the app was shut down
+    a.Run(context.Background())
+}
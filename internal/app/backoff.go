@@ -0,0 +1,16 @@
+package app
+
+import "time"
+
+// backoff is the exponential backoff of the given attempt, starting at 1,
+// the base delay is doubled on every attempt and capped at maxDelay
+func backoff(attempt int, base, maxDelay time.Duration) time.Duration {
+    delay := base
+    for i := 1; i < attempt; i++ {
+        delay *= 2
+        if delay >= maxDelay {
+            return maxDelay
+        }
+    }
+    return delay
+}
@@ -0,0 +1,24 @@
+package app
+
+import (
+    "testing"
+    "time"
+)
+
+func TestBackoff(t *testing.T) {
+    expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+    for i, delay := range expected {
+        if backoff(i+1, reconnectBaseDelay, reconnectMaxDelay) != delay {
+            t.Fatalf(
+                "Attempt %d should wait %s, got %s",
+                i+1,
+                delay,
+                backoff(i+1, reconnectBaseDelay, reconnectMaxDelay),
+            )
+        }
+    }
+
+    if backoff(10, reconnectBaseDelay, reconnectMaxDelay) != reconnectMaxDelay {
+        t.Fatal("Delay should be capped")
+    }
+}
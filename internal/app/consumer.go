@@ -0,0 +1,430 @@
+package app
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "time"
+
+    "github.com/goccy/go-json"
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/logging"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/metrics"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/tracing"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/codes"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans of the consumed tracking messages
+var tracer = otel.Tracer("github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/app")
+
+const (
+    // consumerTag identifies the consumer on its channel, so it can be cancelled on shutdown
+    consumerTag = "vehicle-svc"
+
+    headerFailureReason = "x-failure-reason"
+    headerFailureType   = "x-failure-type"
+    headerFailedAt      = "x-failed-at"
+    headerOriginalQueue = "x-original-queue"
+    // headerRetryCount is how many times the message was retried, it is kept when the message is published again
+    headerRetryCount = "x-retry-count"
+
+    failureTypePoison           = "poison"
+    failureTypeRetriesExhausted = "retries-exhausted"
+    // failureTypeRejected is a status transition which isn't allowed, the reading itself was kept in the history
+    failureTypeRejected = "rejected"
+
+    // maxRetryDelay caps the exponential backoff between retries
+    maxRetryDelay = 5 * time.Minute
+)
+
+// deadLetterExchange is where poison messages and messages which ran out of retries are published
+func (a *App) deadLetterExchange() string {
+    return a.cfg.VehicleQueue + ".dlx"
+}
+
+// deadLetterQueue keeps the dead-lettered messages until someone inspects them
+func (a *App) deadLetterQueue() string {
+    return a.cfg.VehicleQueue + ".dead"
+}
+
+// retryDelay is the exponential backoff of the given attempt, starting at 1
+func (a *App) retryDelay(attempt int) time.Duration {
+    return backoff(attempt, time.Duration(a.cfg.ConsumerRetryDelayMs)*time.Millisecond, maxRetryDelay)
+}
+
+// declareTopology declares the vehicle queue and the dead-letter exchange/queue,
+// the vehicle queue itself is declared without arguments so existing deployments don't fail with PRECONDITION_FAILED
+func (a *App) declareTopology(channel *amqp.Channel) error {
+    // Declare the tracking queue with durable
+    _, err := channel.QueueDeclare(
+        a.cfg.VehicleQueue,
+        true,
+        false,
+        false,
+        false,
+        nil,
+    )
+    if err != nil {
+        return err
+    }
+
+    if err := channel.ExchangeDeclare(
+        a.deadLetterExchange(),
+        amqp.ExchangeDirect,
+        true,
+        false,
+        false,
+        false,
+        nil,
+    ); err != nil {
+        return err
+    }
+
+    if _, err := channel.QueueDeclare(
+        a.deadLetterQueue(),
+        true,
+        false,
+        false,
+        false,
+        nil,
+    ); err != nil {
+        return err
+    }
+
+    return channel.QueueBind(
+        a.deadLetterQueue(),
+        a.cfg.VehicleQueue,
+        a.deadLetterExchange(),
+        false,
+        nil,
+    )
+}
+
+// Consume listens for messages from RabbitMQ and processes them,
+// it blocks until the channel stops delivering (e.g. the connection was lost or the context is done)
+// and the delivered messages are handled
+func (a *App) Consume(
+    ctx context.Context,
+    vehicleService services.VehicleService,
+    channel *amqp.Channel,
+) error {
+    if err := a.declareTopology(channel); err != nil {
+        return err
+    }
+
+    // the broker won't push more than the prefetch count of unacked messages,
+    // so a backlog can't turn into thousands of concurrent updates
+    if err := channel.Qos(a.cfg.ConsumerPrefetch, 0, false); err != nil {
+        return err
+    }
+
+    // Start consuming messages from the declared queue
+    trackingDataMessages, err := channel.Consume(
+        a.cfg.VehicleQueue,
+        consumerTag,
+        false,
+        false,
+        false,
+        false,
+        nil,
+    )
+    if err != nil {
+        return err
+    }
+    a.consuming.Store(true)
+    defer a.consuming.Store(false)
+
+    // messages of the same vehicle are handled one at a time, so they are never applied concurrently or out of order,
+    // once a message of a vehicle was requeued on shutdown, the vehicle's later messages are requeued behind it
+    pool := newDeliveryPool(
+        a.cfg.ConsumerWorkers,
+        func(msg amqp.Delivery) bool {
+            return a.handleDelivery(ctx, msg, vehicleService)
+        },
+        func(msg amqp.Delivery) {
+            a.requeue(logging.WithMessageID(context.Background(), msg.MessageId), msg)
+        },
+    )
+    defer pool.Close()
+
+    stopped := make(chan struct{})
+    defer close(stopped)
+    go func() {
+        select {
+        case <-ctx.Done():
+            // the broker stops delivering and the deliveries channel is closed,
+            // the prefetched messages which weren't handled yet are requeued once the channel is closed
+            if err := channel.Cancel(consumerTag, false); err != nil {
+                slog.ErrorContext(ctx, "Failed to cancel the consumer", slog.Any("error", err))
+            }
+        case <-stopped:
+        }
+    }()
+
+    for msg := range trackingDataMessages {
+        pool.Dispatch(vehicleKey(msg), msg)
+    }
+    return nil
+}
+
+// vehicleKey returns the vehicle ID of the tracking message, it is used to pick the worker of the delivery,
+// a message which can't be parsed still goes through a worker so it can be dead-lettered
+func vehicleKey(msg amqp.Delivery) string {
+    var key struct {
+        VehicleID string `json:"vehicle_id"`
+    }
+    if err := json.Unmarshal(msg.Body, &key); err != nil {
+        return msg.MessageId
+    }
+    return key.VehicleID
+}
+
+// handleDelivery processes a single tracking message, it is acked on success,
+// retried with backoff on transient failures and dead-lettered otherwise.
+// The retries wait on the vehicle's worker, so the later messages of the vehicle stay behind the retried one,
+// a retry which is still waiting when consuming stops (stop is done) is published again with its retry count,
+// so the retries are bounded across redeliveries and restarts, then it returns false
+func (a *App) handleDelivery(
+    stop context.Context,
+    msg amqp.Delivery,
+    vehicleService services.VehicleService,
+) bool {
+    // continue the trace of the publisher, and log with the message's IDs,
+    // so the request and the applied tracking data can be correlated
+    ctx := logging.WithRequestID(context.Background(), msg.CorrelationId)
+    ctx = logging.WithMessageID(ctx, msg.MessageId)
+    ctx, span := tracer.Start(
+        tracing.ExtractAMQP(ctx, msg.Headers),
+        a.cfg.VehicleQueue+" process",
+        trace.WithSpanKind(trace.SpanKindConsumer),
+        trace.WithAttributes(
+            semconv.MessagingSystemRabbitmq,
+            semconv.MessagingOperationTypeDeliver,
+            semconv.MessagingDestinationName(a.cfg.VehicleQueue),
+            semconv.MessagingMessageID(msg.MessageId),
+        ),
+    )
+    defer span.End()
+
+    var trackingData models.TrackingDataRequest
+    if err := json.Unmarshal(msg.Body, &trackingData); err != nil {
+        slog.ErrorContext(ctx, "Failed to unmarshal message", slog.Any("error", err))
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+        a.deadLetter(ctx, msg, failureTypePoison, err)
+        return true
+    }
+    slog.DebugContext(
+        ctx,
+        "Received tracking data",
+        slog.String("vehicle_id", trackingData.VehicleID),
+        slog.String("status", string(trackingData.Status)),
+    )
+
+    // the retries of the previous deliveries are counted in the retry count header
+    retries := retryCount(msg.Headers)
+    for {
+        // Update vehicle mileage and keep the tracking history using vehicle service
+        err := vehicleService.TrackingVehicle(ctx, msg.MessageId, &trackingData)
+        if err == nil {
+            // Acknowledge the message after processing
+            ack(ctx, msg, metrics.OutcomeAck)
+            return true
+        }
+
+        // a redelivered message was already applied, it only has to be acked
+        if errors.Is(err, services.ErrDuplicateMessage) {
+            slog.InfoContext(ctx, "Skipping duplicate message")
+            ack(ctx, msg, metrics.OutcomeAck)
+            return true
+        }
+
+        slog.WarnContext(ctx, "Failed to track vehicle", slog.Any("error", err), slog.Int("attempt", retries+1))
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+        // the rejected reading is already recorded as an anomaly, processing it again won't help
+        if errors.Is(err, repositories.ErrMileageDecreased) {
+            ack(ctx, msg, metrics.OutcomeAck)
+            return true
+        }
+        // a status transition that isn't allowed won't become allowed, it is dead-lettered with the reason,
+        // so the rejected status can be inspected
+        if errors.Is(err, services.ErrInvalidStatusTransition) {
+            a.deadLetter(ctx, msg, failureTypeRejected, err)
+            return true
+        }
+
+        if !isTransient(err) {
+            a.deadLetter(ctx, msg, failureTypePoison, err)
+            return true
+        }
+
+        if retries >= *a.cfg.ConsumerMaxRetries {
+            a.deadLetter(ctx, msg, failureTypeRetriesExhausted, err)
+            return true
+        }
+        retries++
+        msg.Headers = withRetryCount(msg.Headers, retries)
+        if !a.waitRetry(ctx, stop.Done(), retries) {
+            // consuming stopped, the message goes back to the queue and is processed again after the restart
+            a.requeue(ctx, msg)
+            return false
+        }
+    }
+}
+
+// waitRetry waits for the retry delay of the given attempt on the calling worker,
+// it returns false without waiting out the delay when stop is closed
+func (a *App) waitRetry(ctx context.Context, stop <-chan struct{}, attempt int) bool {
+    delay := a.retryDelay(attempt)
+    slog.InfoContext(ctx, "Retrying message", slog.Duration("delay", delay), slog.Int("attempt", attempt))
+    metrics.ConsumedMessages.WithLabelValues(metrics.OutcomeRetry).Inc()
+
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return true
+    case <-stop:
+        return false
+    }
+}
+
+// deadLetter publishes the message to the dead-letter exchange with the failure reason attached as headers,
+// and acks the original once the broker confirmed the copy, if it can't be published it is requeued so it isn't lost
+func (a *App) deadLetter(
+    ctx context.Context,
+    msg amqp.Delivery,
+    failureType string,
+    reason error,
+) {
+    headers := copyHeaders(msg.Headers)
+    headers[headerFailureType] = failureType
+    headers[headerFailureReason] = reason.Error()
+    headers[headerFailedAt] = time.Now().UTC().Format(time.RFC3339)
+    headers[headerOriginalQueue] = a.cfg.VehicleQueue
+
+    if err := a.publish(ctx, a.deadLetterExchange(), a.cfg.VehicleQueue, republishing(msg, headers)); err != nil {
+        slog.ErrorContext(ctx, "Failed to dead-letter message", slog.Any("error", err))
+        nack(ctx, msg, true)
+        return
+    }
+
+    slog.WarnContext(
+        ctx,
+        "Dead-lettered message",
+        slog.String("failure_type", failureType),
+        slog.Any("error", reason),
+    )
+    ack(ctx, msg, metrics.OutcomeDeadLetter)
+}
+
+// requeue publishes the message to the vehicle queue again, with its headers, and acks the original
+// once the broker confirmed the copy, if it can't be published the original is nacked back to the queue
+func (a *App) requeue(ctx context.Context, msg amqp.Delivery) {
+    if err := a.publish(ctx, "", a.cfg.VehicleQueue, republishing(msg, msg.Headers)); err != nil {
+        slog.ErrorContext(ctx, "Failed to requeue message", slog.Any("error", err))
+        nack(ctx, msg, true)
+        return
+    }
+    ack(ctx, msg, metrics.OutcomeNack)
+}
+
+// publish publishes the message through the confirm mode channels of the publisher,
+// it returns once the broker confirmed the message, so the original delivery can be acked
+func (a *App) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+    ctx, cancel := context.WithTimeout(ctx, time.Duration(a.cfg.PublishConfirmTimeoutMs)*time.Millisecond)
+    defer cancel()
+    return a.publishPool.Publish(ctx, exchange, key, msg)
+}
+
+// ack acks the message and counts its outcome
+func ack(ctx context.Context, msg amqp.Delivery, outcome string) {
+    if err := msg.Ack(false); err != nil {
+        slog.ErrorContext(ctx, "Failed to ack message", slog.Any("error", err))
+        return
+    }
+    metrics.ConsumedMessages.WithLabelValues(outcome).Inc()
+}
+
+// nack nacks the message, it goes back to the queue if requeue is set and is dropped otherwise
+func nack(ctx context.Context, msg amqp.Delivery, requeue bool) {
+    if err := msg.Nack(false, requeue); err != nil {
+        slog.ErrorContext(ctx, "Failed to nack message", slog.Any("error", err))
+        return
+    }
+    metrics.ConsumedMessages.WithLabelValues(metrics.OutcomeNack).Inc()
+}
+
+// isTransient reports whether the error is worth retrying, e.g. database timeouts or network errors,
+// a vehicle whose status was changed meanwhile is retried as well, the transition is checked again on its new status,
+// and so is a message which is claimed by another consumer, until that one marked it as processed or gave it up
+func isTransient(err error) bool {
+    if errors.Is(err, context.DeadlineExceeded) ||
+        errors.Is(err, repositories.ErrVehicleStatusChanged) ||
+        errors.Is(err, repositories.ErrMessageInProgress) {
+        return true
+    }
+    if mongo.IsTimeout(err) || mongo.IsNetworkError(err) {
+        return true
+    }
+    var serverErr mongo.ServerError
+    if errors.As(err, &serverErr) {
+        return serverErr.HasErrorLabel("RetryableWriteError") ||
+            serverErr.HasErrorLabel("TransientTransactionError")
+    }
+    return false
+}
+
+// retryCount reads the retry count header, the broker may hand it back as any integer type
+func retryCount(headers amqp.Table) int {
+    switch count := headers[headerRetryCount].(type) {
+    case int:
+        return count
+    case int8:
+        return int(count)
+    case int16:
+        return int(count)
+    case int32:
+        return int(count)
+    case int64:
+        return int(count)
+    }
+    return 0
+}
+
+// withRetryCount returns a copy of the headers with the retry count set
+func withRetryCount(headers amqp.Table, retries int) amqp.Table {
+    headers = copyHeaders(headers)
+    headers[headerRetryCount] = int32(retries)
+    return headers
+}
+
+func copyHeaders(headers amqp.Table) amqp.Table {
+    copied := make(amqp.Table, len(headers)+1)
+    for key, value := range headers {
+        copied[key] = value
+    }
+    return copied
+}
+
+// republishing keeps the properties of the delivery when it is published again
+func republishing(msg amqp.Delivery, headers amqp.Table) amqp.Publishing {
+    return amqp.Publishing{
+        Headers:       headers,
+        ContentType:   msg.ContentType,
+        DeliveryMode:  amqp.Persistent,
+        CorrelationId: msg.CorrelationId,
+        MessageId:     msg.MessageId,
+        Timestamp:     msg.Timestamp,
+        Type:          msg.Type,
+        Body:          msg.Body,
+    }
+}
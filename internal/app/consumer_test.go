@@ -0,0 +1,85 @@
+package app
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "testing"
+    "time"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/config"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+)
+
+func TestApp_RetryDelay(t *testing.T) {
+    maxRetries := 12
+    a := NewApp().SetConfig(
+        &config.EnvConfig{
+            VehicleQueue:         "vehicles",
+            ConsumerMaxRetries:   &maxRetries,
+            ConsumerRetryDelayMs: 500,
+        },
+    )
+
+    expected := []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second}
+    for i, delay := range expected {
+        if a.retryDelay(i+1) != delay {
+            t.Fatalf("Attempt %d should wait %s, got %s", i+1, delay, a.retryDelay(i+1))
+        }
+    }
+
+    if a.retryDelay(12) != maxRetryDelay {
+        t.Fatal("Retry delay should be capped")
+    }
+}
+
+func TestApp_WaitRetryStops(t *testing.T) {
+    a := NewApp().SetConfig(&config.EnvConfig{VehicleQueue: "vehicles", ConsumerRetryDelayMs: 60000})
+
+    stop := make(chan struct{})
+    close(stop)
+
+    if a.waitRetry(context.Background(), stop, 1) {
+        t.Fatal("Backoff should give up when consuming stops")
+    }
+}
+
+func TestRetryCount(t *testing.T) {
+    msg := amqp.Delivery{Headers: amqp.Table{"traceparent": "00-trace-span-01"}}
+    if retryCount(msg.Headers) != 0 {
+        t.Fatal("Message without the header should not have been retried")
+    }
+
+    retried := republishing(msg, withRetryCount(msg.Headers, retryCount(msg.Headers)+1))
+    if retryCount(retried.Headers) != 1 || retried.Headers["traceparent"] == nil {
+        t.Fatalf("Retry count should be incremented and the other headers kept, got %v", retried.Headers)
+    }
+    if _, ok := msg.Headers[headerRetryCount]; ok {
+        t.Fatal("Headers of the delivery should not be changed")
+    }
+
+    // the broker may hand the header back as another integer type
+    redelivered := amqp.Delivery{Headers: amqp.Table{headerRetryCount: int64(retryCount(retried.Headers))}}
+    if retryCount(withRetryCount(redelivered.Headers, retryCount(redelivered.Headers)+1)) != 2 {
+        t.Fatal("Retry count of a redelivered message should be incremented")
+    }
+}
+
+func TestIsTransient(t *testing.T) {
+    if !isTransient(fmt.Errorf("find vehicle: %w", context.DeadlineExceeded)) {
+        t.Fatal("Deadline exceeded should be transient")
+    }
+
+    if !isTransient(repositories.ErrVehicleStatusChanged) {
+        t.Fatal("Status changed meanwhile should be transient")
+    }
+
+    if !isTransient(repositories.ErrMessageInProgress) {
+        t.Fatal("Message claimed by another consumer should be transient")
+    }
+
+    if isTransient(errors.New("vehicle not found")) {
+        t.Fatal("Not found should not be transient")
+    }
+}
@@ -0,0 +1,35 @@
+package app
+
+import (
+    "context"
+    "errors"
+
+    "go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var (
+    ErrRabbitDisconnected = errors.New("rabbitmq connection is closed")
+    ErrConsumerStopped    = errors.New("tracking consumer is not running")
+)
+
+// pingMongo checks that the primary answers, the vehicles can't be written without it
+func (a *App) pingMongo(ctx context.Context) error {
+    return a.db.Ping(ctx, readpref.Primary())
+}
+
+// checkRabbit checks the consumer's channel, which is closed together with its connection
+func (a *App) checkRabbit(context.Context) error {
+    channel := a.consumerChannel.Load()
+    if channel == nil || channel.IsClosed() {
+        return ErrRabbitDisconnected
+    }
+    return nil
+}
+
+// checkConsumer checks that the tracking messages are consumed, e.g. it is down while reconnecting
+func (a *App) checkConsumer(context.Context) error {
+    if !a.consuming.Load() {
+        return ErrConsumerStopped
+    }
+    return nil
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "sync/atomic"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/logging"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/metrics"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/propagation"
+)
+
+const (
+    // outboxLease is how long a claimed message is hidden from other relays while it is being published
+    outboxLease = 30 * time.Second
+    // maxOutboxRetryDelay caps the exponential backoff of a message which can't be published
+    maxOutboxRetryDelay = 5 * time.Minute
+)
+
+// outboxRelay publishes the tracking messages queued in the outbox,
+// a message stays pending until the broker confirmed it, so nothing is lost while RabbitMQ is down
+type outboxRelay struct {
+    outbox    repositories.OutboxRepository
+    publisher repositories.TrackingRepository
+    interval  time.Duration
+    // maxAttempts is how many times a message is published before it is marked as failed
+    maxAttempts int
+    // backlog is the number of pending messages seen on the last poll
+    backlog atomic.Int64
+}
+
+func newOutboxRelay(
+    outbox repositories.OutboxRepository,
+    publisher repositories.TrackingRepository,
+    interval time.Duration,
+    maxAttempts int,
+) *outboxRelay {
+    return &outboxRelay{
+        outbox:      outbox,
+        publisher:   publisher,
+        interval:    interval,
+        maxAttempts: maxAttempts,
+    }
+}
+
+// Backlog returns the number of messages waiting to be published
+func (r *outboxRelay) Backlog() int64 {
+    return r.backlog.Load()
+}
+
+// Run polls the outbox until the context is done
+func (r *outboxRelay) Run(ctx context.Context) {
+    ticker := time.NewTicker(r.interval)
+    defer ticker.Stop()
+
+    for {
+        r.relayPending(ctx)
+        r.refreshBacklog(ctx)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// relayPending publishes the due messages one by one,
+// it stops at the first failure because the broker is most likely unavailable,
+// unless the message ran out of attempts, then it is marked as failed and the next one is published
+func (r *outboxRelay) relayPending(ctx context.Context) {
+    for ctx.Err() == nil {
+        message, err := r.outbox.ClaimPending(ctx, outboxLease)
+        if err != nil {
+            slog.ErrorContext(ctx, "Failed to claim outbox message", slog.Any("error", err))
+            return
+        }
+        if message == nil {
+            return
+        }
+
+        // the message is published within the trace, and with the request ID, of the request which queued it
+        publishCtx := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(message.TraceContext))
+        publishCtx = logging.WithRequestID(publishCtx, message.CorrelationID)
+        if err := r.publisher.PublishTrackingData(publishCtx, message.MessageID, message.Payload); err != nil {
+            slog.WarnContext(
+                publishCtx,
+                "Failed to publish outbox message",
+                slog.String("message_id", message.MessageID),
+                slog.Int("attempts", message.Attempts+1),
+                slog.Any("error", err),
+            )
+            if r.giveUp(message, err) {
+                slog.ErrorContext(
+                    publishCtx,
+                    "Gave up on outbox message",
+                    slog.String("message_id", message.MessageID),
+                    slog.Int("attempts", message.Attempts+1),
+                    slog.Any("error", err),
+                )
+                if err := r.outbox.MarkUndeliverable(ctx, message.ID, err.Error()); err != nil {
+                    slog.ErrorContext(
+                        publishCtx,
+                        "Failed to mark outbox message as undeliverable",
+                        slog.Any("error", err),
+                    )
+                    return
+                }
+                metrics.OutboxFailedMessages.Inc()
+                continue
+            }
+            nextAttemptAt := time.Now().Add(backoff(message.Attempts+1, r.interval, maxOutboxRetryDelay))
+            if err := r.outbox.MarkFailed(ctx, message.ID, err.Error(), nextAttemptAt); err != nil {
+                slog.ErrorContext(publishCtx, "Failed to mark outbox message as failed", slog.Any("error", err))
+            }
+            return
+        }
+
+        if err := r.outbox.MarkSent(ctx, message.ID); err != nil {
+            // the lease expires and the message is published again, the consumer skips it by its message ID
+            slog.ErrorContext(publishCtx, "Failed to mark outbox message as sent", slog.Any("error", err))
+        }
+    }
+}
+
+// giveUp reports whether the failed message ran out of attempts,
+// a broker which can't be reached isn't the message's fault, so the message keeps waiting for it
+func (r *outboxRelay) giveUp(message *repositories.OutboxMessage, err error) bool {
+    return message.Attempts+1 >= r.maxAttempts && !errors.Is(err, repositories.ErrChannelUnavailable)
+}
+
+func (r *outboxRelay) refreshBacklog(ctx context.Context) {
+    backlog, err := r.outbox.CountPending(ctx)
+    if err != nil {
+        slog.ErrorContext(ctx, "Failed to count outbox backlog", slog.Any("error", err))
+        return
+    }
+    r.backlog.Store(backlog)
+}
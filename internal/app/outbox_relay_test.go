@@ -0,0 +1,124 @@
+package app
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryOutbox is an outbox of the messages in memory, the oldest pending message is claimed first
+type memoryOutbox struct {
+    messages []*repositories.OutboxMessage
+}
+
+func (o *memoryOutbox) AddMessage(_ context.Context, messageID string, payload []byte) error {
+    o.messages = append(
+        o.messages, &repositories.OutboxMessage{
+            ID:        primitive.NewObjectID(),
+            MessageID: messageID,
+            Payload:   payload,
+            Status:    repositories.OutboxStatusPending,
+        },
+    )
+    return nil
+}
+
+func (o *memoryOutbox) ClaimPending(context.Context, time.Duration) (*repositories.OutboxMessage, error) {
+    for _, message := range o.messages {
+        if message.Status == repositories.OutboxStatusPending {
+            if message.NextAttemptAt.After(time.Now()) {
+                return nil, nil
+            }
+            claimed := *message
+            return &claimed, nil
+        }
+    }
+    return nil, nil
+}
+
+func (o *memoryOutbox) find(id primitive.ObjectID) *repositories.OutboxMessage {
+    for _, message := range o.messages {
+        if message.ID == id {
+            return message
+        }
+    }
+    return nil
+}
+
+func (o *memoryOutbox) MarkSent(_ context.Context, id primitive.ObjectID) error {
+    message := o.find(id)
+    message.Status = repositories.OutboxStatusSent
+    message.Attempts++
+    return nil
+}
+
+func (o *memoryOutbox) MarkFailed(_ context.Context, id primitive.ObjectID, reason string, next time.Time) error {
+    message := o.find(id)
+    message.LastError, message.NextAttemptAt = reason, next
+    message.Attempts++
+    return nil
+}
+
+func (o *memoryOutbox) MarkUndeliverable(_ context.Context, id primitive.ObjectID, reason string) error {
+    message := o.find(id)
+    message.Status, message.LastError = repositories.OutboxStatusFailed, reason
+    message.Attempts++
+    return nil
+}
+
+func (o *memoryOutbox) CountPending(context.Context) (int64, error) {
+    return 0, nil
+}
+
+// failingPublisher fails the publishes of the messages in failures with their error
+type failingPublisher struct {
+    failures  map[string]error
+    published []string
+}
+
+func (p *failingPublisher) PublishTrackingData(_ context.Context, messageID string, _ []byte) error {
+    if err := p.failures[messageID]; err != nil {
+        return err
+    }
+    p.published = append(p.published, messageID)
+    return nil
+}
+
+func TestOutboxRelay_GivesUpAfterMaxAttempts(t *testing.T) {
+    outbox := &memoryOutbox{}
+    for _, messageID := range []string{"returned", "next"} {
+        if err := outbox.AddMessage(context.Background(), messageID, []byte(`{}`)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    outbox.messages[0].Attempts = 2
+
+    publisher := &failingPublisher{failures: map[string]error{"returned": repositories.ErrPublishReturned}}
+    relay := newOutboxRelay(outbox, publisher, time.Millisecond, 3)
+    relay.relayPending(context.Background())
+
+    if outbox.messages[0].Status != repositories.OutboxStatusFailed || outbox.messages[0].Attempts != 3 {
+        t.Fatalf("Message should be failed after its last attempt, got %+v", outbox.messages[0])
+    }
+    if len(publisher.published) != 1 || publisher.published[0] != "next" {
+        t.Fatalf("Next message should be published after the failed one, got %v", publisher.published)
+    }
+}
+
+func TestOutboxRelay_WaitsForUnreachableBroker(t *testing.T) {
+    outbox := &memoryOutbox{}
+    if err := outbox.AddMessage(context.Background(), "waiting", []byte(`{}`)); err != nil {
+        t.Fatal(err)
+    }
+    outbox.messages[0].Attempts = 5
+
+    publisher := &failingPublisher{failures: map[string]error{"waiting": repositories.ErrChannelUnavailable}}
+    newOutboxRelay(outbox, publisher, time.Millisecond, 3).relayPending(context.Background())
+
+    if outbox.messages[0].Status != repositories.OutboxStatusPending {
+        t.Fatal("Message should stay pending while the broker can't be reached")
+    }
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "time"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/services"
+)
+
+const (
+    reconnectBaseDelay = time.Second
+    reconnectMaxDelay  = 30 * time.Second
+)
+
+// superviseRabbit keeps the consumer running across broker restarts,
+// whenever the channel is closed it reconnects with backoff, re-declares the queues and restarts Consume.
+// The publisher recovers on its own, its pool replaces the closed channels
+func (a *App) superviseRabbit(
+    ctx context.Context,
+    vehicleService services.VehicleService,
+) {
+    attempt := 0
+    for ctx.Err() == nil {
+        if err := a.runRabbit(ctx, vehicleService); err != nil {
+            slog.ErrorContext(ctx, "RabbitMQ is not available", slog.Any("error", err))
+            attempt++
+        } else {
+            // the channel was up and running, so the next reconnect starts with the shortest delay
+            attempt = 1
+        }
+        if ctx.Err() != nil {
+            // the consumer was cancelled because the app is shutting down
+            return
+        }
+
+        delay := backoff(attempt, reconnectBaseDelay, reconnectMaxDelay)
+        slog.InfoContext(ctx, "Reconnecting to RabbitMQ", slog.Duration("delay", delay))
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(delay):
+        }
+    }
+}
+
+// runRabbit opens the consumer's channel (reconnecting if the connection is closed) and consumes until it is closed
+func (a *App) runRabbit(
+    ctx context.Context,
+    vehicleService services.VehicleService,
+) error {
+    channel, err := a.rabbitConn.Channel()
+    if err != nil {
+        return err
+    }
+    // the channel is closed on every return, so a channel whose topology or Consume failed isn't left open
+    // while the next attempt opens another one
+    defer func() {
+        if err := channel.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+            slog.WarnContext(ctx, "Failed to close RabbitMQ channel", slog.Any("error", err))
+        }
+    }()
+    closed := channel.NotifyClose(make(chan *amqp.Error, 1))
+    a.consumerChannel.Store(channel)
+
+    slog.InfoContext(ctx, "Connected to RabbitMQ", slog.String("queue", a.cfg.VehicleQueue))
+    if err := a.Consume(ctx, vehicleService, channel); err != nil {
+        return err
+    }
+
+    // the deliveries stop when the channel is closed, the reason (if any) is on the close notification,
+    // or when the consumer was cancelled, then the handled deliveries were acked and the channel can be closed
+    select {
+    case err, ok := <-closed:
+        if ok && err != nil {
+            slog.WarnContext(ctx, "RabbitMQ channel closed", slog.Any("error", err))
+        }
+    case <-ctx.Done():
+    }
+    return nil
+}
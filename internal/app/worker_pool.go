@@ -0,0 +1,123 @@
+package app
+
+import (
+    "sync"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// deliveryPool runs a fixed number of workers and queues the deliveries by their key,
+// a key is only handled by one worker at a time, so deliveries with the same key are processed one at a time
+// and in the order they were dispatched, while a key which is backing off doesn't hold up the other keys.
+// Once a delivery of a key was requeued instead of handled, the later deliveries of the key are requeued as well,
+// so they aren't applied before it
+type deliveryPool struct {
+    mu   sync.Mutex
+    cond *sync.Cond
+    // queues are the deliveries of every key which has any, the first one is being handled or waits for a worker
+    queues map[string][]amqp.Delivery
+    // ready are the keys which wait for a worker, a key is in there at most once
+    ready []string
+    // requeued are the keys of which a delivery was requeued
+    requeued map[string]bool
+    closed   bool
+    wg       sync.WaitGroup
+}
+
+// newDeliveryPool starts size workers, the number of queued deliveries is bounded by the consumer's prefetch.
+// handle returns false when it requeued the delivery instead of handling it,
+// the deliveries of the key which are queued or dispatched after it are then passed to requeue
+func newDeliveryPool(
+    size int,
+    handle func(msg amqp.Delivery) bool,
+    requeue func(msg amqp.Delivery),
+) *deliveryPool {
+    if size < 1 {
+        size = 1
+    }
+    pool := &deliveryPool{queues: map[string][]amqp.Delivery{}, requeued: map[string]bool{}}
+    pool.cond = sync.NewCond(&pool.mu)
+    for range size {
+        pool.wg.Add(1)
+        go func() {
+            defer pool.wg.Done()
+            for {
+                key, msg, requeued, ok := pool.next()
+                if !ok {
+                    return
+                }
+                if requeued {
+                    requeue(msg)
+                } else if !handle(msg) {
+                    pool.requeueKey(key)
+                }
+                pool.finish(key)
+            }
+        }()
+    }
+    return pool
+}
+
+// Dispatch queues the delivery behind the other deliveries of its key
+func (p *deliveryPool) Dispatch(key string, msg amqp.Delivery) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if queue, ok := p.queues[key]; ok {
+        p.queues[key] = append(queue, msg)
+        return
+    }
+    p.queues[key] = []amqp.Delivery{msg}
+    p.ready = append(p.ready, key)
+    p.cond.Signal()
+}
+
+// next waits for a key which isn't handled by another worker and returns its first delivery,
+// and whether the key was requeued. It returns false once the pool is closed and there is nothing left to handle
+func (p *deliveryPool) next() (string, amqp.Delivery, bool, bool) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    for len(p.ready) == 0 {
+        if p.closed {
+            return "", amqp.Delivery{}, false, false
+        }
+        p.cond.Wait()
+    }
+    key := p.ready[0]
+    p.ready = p.ready[1:]
+    return key, p.queues[key][0], p.requeued[key], true
+}
+
+// requeueKey marks the key as requeued, its remaining deliveries are requeued instead of handled
+func (p *deliveryPool) requeueKey(key string) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.requeued[key] = true
+}
+
+// finish removes the handled delivery of the key, the key waits for a worker again if it has more
+func (p *deliveryPool) finish(key string) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    queue := p.queues[key][1:]
+    if len(queue) == 0 {
+        delete(p.queues, key)
+        return
+    }
+    p.queues[key] = queue
+    p.ready = append(p.ready, key)
+    p.cond.Signal()
+}
+
+// Close stops the workers once the dispatched deliveries are handled, and waits for them
+func (p *deliveryPool) Close() {
+    p.mu.Lock()
+    p.closed = true
+    p.cond.Broadcast()
+    p.mu.Unlock()
+
+    p.wg.Wait()
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+    "fmt"
+    "slices"
+    "sync"
+    "testing"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDeliveryPool_KeepsOrderPerKey(t *testing.T) {
+    var mu sync.Mutex
+    received := map[string][]uint64{}
+
+    pool := newDeliveryPool(
+        4,
+        func(msg amqp.Delivery) bool {
+            mu.Lock()
+            defer mu.Unlock()
+            received[msg.MessageId] = append(received[msg.MessageId], msg.DeliveryTag)
+            return true
+        },
+        func(msg amqp.Delivery) {
+            t.Errorf("%s should not be requeued", msg.MessageId)
+        },
+    )
+
+    for tag := uint64(1); tag <= 100; tag++ {
+        key := fmt.Sprintf("vehicle-%d", tag%5)
+        pool.Dispatch(key, amqp.Delivery{MessageId: key, DeliveryTag: tag})
+    }
+
+    pool.Close()
+
+    total := 0
+    for key, tags := range received {
+        total += len(tags)
+        for i := 0; i < len(tags)-1; i++ {
+            if tags[i] > tags[i+1] {
+                t.Fatalf("Deliveries of %s should be handled in order", key)
+            }
+        }
+    }
+
+    if total != 100 {
+        t.Fatal("All deliveries should be handled")
+    }
+}
+
+func TestDeliveryPool_BlockedKeyDoesNotBlockOthers(t *testing.T) {
+    release := make(chan struct{})
+    handled := make(chan string, 10)
+
+    pool := newDeliveryPool(
+        2,
+        func(msg amqp.Delivery) bool {
+            if msg.MessageId == "blocked" {
+                <-release
+            }
+            handled <- msg.MessageId
+            return true
+        },
+        func(msg amqp.Delivery) {
+            t.Errorf("%s should not be requeued", msg.MessageId)
+        },
+    )
+
+    pool.Dispatch("vehicle-1", amqp.Delivery{MessageId: "blocked"})
+    pool.Dispatch("vehicle-1", amqp.Delivery{MessageId: "behind"})
+    for i := 0; i < 5; i++ {
+        pool.Dispatch(fmt.Sprintf("vehicle-%d", i+2), amqp.Delivery{MessageId: "other"})
+    }
+
+    // the other vehicles are handled while the first one is blocked, the message behind it waits
+    for i := 0; i < 5; i++ {
+        if id := <-handled; id != "other" {
+            t.Fatalf("Only the other vehicles should be handled, got %s", id)
+        }
+    }
+
+    close(release)
+    pool.Close()
+
+    if <-handled != "blocked" || <-handled != "behind" {
+        t.Fatal("Deliveries of the blocked vehicle should be handled in order")
+    }
+}
+
+func TestDeliveryPool_RequeuesRestOfKey(t *testing.T) {
+    var mu sync.Mutex
+    var handled, requeued []string
+    release := make(chan struct{})
+
+    pool := newDeliveryPool(
+        2,
+        func(msg amqp.Delivery) bool {
+            mu.Lock()
+            defer mu.Unlock()
+            // the message backing off when consuming stops requeues itself
+            if msg.MessageId == "backoff" {
+                <-release
+                requeued = append(requeued, msg.MessageId)
+                return false
+            }
+            handled = append(handled, msg.MessageId)
+            return true
+        },
+        func(msg amqp.Delivery) {
+            mu.Lock()
+            defer mu.Unlock()
+            requeued = append(requeued, msg.MessageId)
+        },
+    )
+
+    pool.Dispatch("vehicle-1", amqp.Delivery{MessageId: "backoff"})
+    pool.Dispatch("vehicle-1", amqp.Delivery{MessageId: "newer-1"})
+    pool.Dispatch("vehicle-2", amqp.Delivery{MessageId: "other"})
+    close(release)
+    pool.Dispatch("vehicle-1", amqp.Delivery{MessageId: "newer-2"})
+    pool.Close()
+
+    if !slices.Equal(requeued, []string{"backoff", "newer-1", "newer-2"}) {
+        t.Fatalf("Later deliveries of the requeued vehicle should be requeued behind it, got %v", requeued)
+    }
+    if !slices.Equal(handled, []string{"other"}) {
+        t.Fatalf("Other vehicles should still be handled, got %v", handled)
+    }
+}
@@ -1,5 +1,26 @@
 package config
 
+const (
+    DefaultConsumerMaxRetries   = 5
+    DefaultConsumerRetryDelayMs = 1000
+    DefaultConsumerPrefetch     = 32
+    DefaultConsumerWorkers      = 8
+    // DefaultMessageDedupTTLSeconds keeps processed message IDs for a day
+    DefaultMessageDedupTTLSeconds = 24 * 60 * 60
+    DefaultOutboxPollIntervalMs   = 1000
+    DefaultPublisherChannels      = 8
+    DefaultShutdownTimeoutMs      = 15000
+    DefaultTracingExporter        = "none"
+    DefaultTracingEndpoint        = "http://localhost:4318"
+    DefaultLogLevel               = "info"
+    DefaultLogFormat              = "json"
+    DefaultExactCountLimit        = 10000
+    // DefaultPublishConfirmTimeoutMs is how long a publish waits for the broker's confirmation
+    DefaultPublishConfirmTimeoutMs = 5000
+    // DefaultOutboxMaxAttempts gives a message about an hour of retries (the delay is capped at 5 minutes)
+    DefaultOutboxMaxAttempts = 20
+)
+
 // EnvConfig struct holds the configuration for the application
 type EnvConfig struct {
     Host          string `json:"HOST" validate:"required"`
@@ -10,4 +31,96 @@ type EnvConfig struct {
     VehicleQueue  string `json:"VEHICLE_QUEUE" validate:"required"`
     SignatureKey  string `json:"SIGNATURE_KEY" validate:"required"`
     AuthSvc       string `json:"AUTH_SVC" validate:"required"`
+
+    // MileageTolerance is how far below the stored mileage a tracking reading may be before it is rejected
+    MileageTolerance float64 `json:"MILEAGE_TOLERANCE,string" validate:"gte=0"`
+    // ExactCountLimit is how many matching vehicles are counted exactly, the total of a list above it is estimated,
+    // it is a pointer so 0 (count every match) can be told apart from a missing setting
+    ExactCountLimit *int `json:"EXACT_COUNT_LIMIT,string" validate:"omitempty,gte=0"`
+
+    // ConsumerMaxRetries is how many times a tracking message is retried after a transient failure
+    // before it is dead-lettered, it is a pointer so 0 (no retries) can be told apart from a missing setting
+    ConsumerMaxRetries *int `json:"CONSUMER_MAX_RETRIES,string" validate:"omitempty,gte=0"`
+    // ConsumerRetryDelayMs is the delay before the first retry, it doubles on every attempt
+    ConsumerRetryDelayMs int `json:"CONSUMER_RETRY_DELAY_MS,string" validate:"gte=0"`
+    // ConsumerPrefetch is how many unacked tracking messages the broker pushes to the consumer
+    ConsumerPrefetch int `json:"CONSUMER_PREFETCH,string" validate:"gte=0"`
+    // ConsumerWorkers is how many tracking messages are processed concurrently
+    ConsumerWorkers int `json:"CONSUMER_WORKERS,string" validate:"gte=0"`
+    // MessageDedupTTLSeconds is how long the ID of a processed tracking message is remembered
+    MessageDedupTTLSeconds int `json:"MESSAGE_DEDUP_TTL_SECONDS,string" validate:"gte=0"`
+    // OutboxPollIntervalMs is how often the outbox relay looks for tracking data to publish,
+    // it is also the first retry delay of a message which couldn't be published
+    OutboxPollIntervalMs int `json:"OUTBOX_POLL_INTERVAL_MS,string" validate:"gte=0"`
+    // OutboxMaxAttempts is how many times the outbox relay tries to publish a message before it is marked as failed
+    OutboxMaxAttempts int `json:"OUTBOX_MAX_ATTEMPTS,string" validate:"gte=0"`
+    // PublisherChannels is the size of the channel pool used to publish tracking data
+    PublisherChannels int `json:"PUBLISHER_CHANNELS,string" validate:"gte=0"`
+    // PublishConfirmTimeoutMs is how long a published tracking message waits for the broker's confirmation
+    // before the outbox relay retries it
+    PublishConfirmTimeoutMs int `json:"PUBLISH_CONFIRM_TIMEOUT_MS,string" validate:"gte=0"`
+    // ShutdownTimeoutMs is how long the in-flight requests and tracking messages are waited for on shutdown
+    ShutdownTimeoutMs int `json:"SHUTDOWN_TIMEOUT_MS,string" validate:"gte=0"`
+
+    // TracingExporter is where the spans are sent, otlp (a collector at TracingEndpoint), stdout or none
+    TracingExporter string `json:"TRACING_EXPORTER" validate:"omitempty,oneof=otlp stdout none"`
+    // TracingEndpoint is the URL of the OTLP/HTTP collector
+    TracingEndpoint string `json:"TRACING_ENDPOINT" validate:"omitempty,url"`
+
+    // LogLevel is the lowest level which is logged, debug, info, warn or error
+    LogLevel string `json:"LOG_LEVEL" validate:"omitempty,oneof=debug info warn error"`
+    // LogFormat is json (one object per record) or text (key=value pairs)
+    LogFormat string `json:"LOG_FORMAT" validate:"omitempty,oneof=json text"`
+}
+
+// SetDefaults fills the optional settings which were not provided in the env file
+func (c *EnvConfig) SetDefaults() *EnvConfig {
+    if c.ConsumerMaxRetries == nil {
+        maxRetries := DefaultConsumerMaxRetries
+        c.ConsumerMaxRetries = &maxRetries
+    }
+    if c.ConsumerRetryDelayMs == 0 {
+        c.ConsumerRetryDelayMs = DefaultConsumerRetryDelayMs
+    }
+    if c.ConsumerPrefetch == 0 {
+        c.ConsumerPrefetch = DefaultConsumerPrefetch
+    }
+    if c.ConsumerWorkers == 0 {
+        c.ConsumerWorkers = DefaultConsumerWorkers
+    }
+    if c.MessageDedupTTLSeconds == 0 {
+        c.MessageDedupTTLSeconds = DefaultMessageDedupTTLSeconds
+    }
+    if c.OutboxPollIntervalMs == 0 {
+        c.OutboxPollIntervalMs = DefaultOutboxPollIntervalMs
+    }
+    if c.OutboxMaxAttempts == 0 {
+        c.OutboxMaxAttempts = DefaultOutboxMaxAttempts
+    }
+    if c.PublisherChannels == 0 {
+        c.PublisherChannels = DefaultPublisherChannels
+    }
+    if c.PublishConfirmTimeoutMs == 0 {
+        c.PublishConfirmTimeoutMs = DefaultPublishConfirmTimeoutMs
+    }
+    if c.ShutdownTimeoutMs == 0 {
+        c.ShutdownTimeoutMs = DefaultShutdownTimeoutMs
+    }
+    if c.TracingExporter == "" {
+        c.TracingExporter = DefaultTracingExporter
+    }
+    if c.TracingEndpoint == "" {
+        c.TracingEndpoint = DefaultTracingEndpoint
+    }
+    if c.LogLevel == "" {
+        c.LogLevel = DefaultLogLevel
+    }
+    if c.LogFormat == "" {
+        c.LogFormat = DefaultLogFormat
+    }
+    if c.ExactCountLimit == nil {
+        exactCountLimit := DefaultExactCountLimit
+        c.ExactCountLimit = &exactCountLimit
+    }
+    return c
 }
@@ -0,0 +1,100 @@
+package handler
+
+import (
+    "errors"
+    "log/slog"
+    "net/http"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+)
+
+const (
+    codeMethodNotAllowed = "method_not_allowed"
+    codeValidationFailed = "validation_failed"
+    codeInternalError    = "internal_error"
+)
+
+var (
+    ErrMethodNotAllowed = errors.New("method was not allowed")
+    ErrNotFound         = repositories.NewError(repositories.KindNotFound, "not_found", "not found")
+    ErrInvalidRequest   = repositories.NewError(repositories.KindInvalidArgument, "invalid_request", "invalid request")
+)
+
+// kindStatus is the status code of every kind of domain error
+var kindStatus = map[repositories.ErrorKind]int{
+    repositories.KindNotFound:        http.StatusNotFound,
+    repositories.KindConflict:        http.StatusConflict,
+    repositories.KindInvalidID:       http.StatusBadRequest,
+    repositories.KindInvalidArgument: http.StatusBadRequest,
+    repositories.KindUnavailable:     http.StatusServiceUnavailable,
+}
+
+// ErrorDetail is the machine-readable part of an error response
+type ErrorDetail struct {
+    Code   string                    `json:"code"`
+    Fields []repositories.FieldError `json:"fields,omitempty"`
+}
+
+// errorStatus maps the error to its status code and error detail:
+// domain errors by their kind, validation errors to 422, and anything else to 500
+func errorStatus(err error) (int, *ErrorDetail) {
+    if errors.Is(err, ErrMethodNotAllowed) {
+        return http.StatusMethodNotAllowed, &ErrorDetail{Code: codeMethodNotAllowed}
+    }
+
+    status, detail := http.StatusInternalServerError, &ErrorDetail{Code: codeInternalError}
+
+    // the handlers translate the errors of the validator, an untranslated one keeps the validator's message
+    var validationErrs validator.ValidationErrors
+    if errors.As(err, &validationErrs) {
+        status, detail = http.StatusUnprocessableEntity, &ErrorDetail{Code: codeValidationFailed}
+        for _, field := range validationErrs {
+            detail.Fields = append(
+                detail.Fields, repositories.FieldError{
+                    Field:   field.Field(),
+                    Rule:    field.Tag(),
+                    Message: field.Error(),
+                },
+            )
+        }
+    }
+
+    var validationErr *repositories.ValidationError
+    if errors.As(err, &validationErr) {
+        status, detail = http.StatusUnprocessableEntity, &ErrorDetail{
+            Code:   codeValidationFailed,
+            Fields: validationErr.Fields,
+        }
+    }
+
+    // the kind of a domain error wins, e.g. a ValidationError of an invalid query parameter is a 400
+    var domainErr *repositories.Error
+    if errors.As(err, &domainErr) {
+        if kindStatus, ok := kindStatus[domainErr.Kind]; ok {
+            status = kindStatus
+        }
+        detail.Code = domainErr.Code
+    }
+
+    return status, detail
+}
+
+// writeError writes the error response of err, every handler reports its errors through it
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+    status, detail := errorStatus(err)
+    if status >= http.StatusInternalServerError {
+        slog.ErrorContext(r.Context(), "Failed to handle request", slog.Any("error", err))
+    }
+
+    response := common.DefaultErrorResponse(err)
+    response.Error = detail
+
+    w.Header().Set(common.ContentType, common.ApplicationJSON)
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(response); err != nil {
+        slog.ErrorContext(r.Context(), "Failed to encode response", slog.Any("error", err))
+    }
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/services"
+)
+
+func TestErrorStatus(t *testing.T) {
+    tests := []struct {
+        err    error
+        status int
+        code   string
+    }{
+        {repositories.ErrVehicleNotFound, http.StatusNotFound, "vehicle_not_found"},
+        {repositories.ErrInvalidVehicleID, http.StatusBadRequest, "invalid_vehicle_id"},
+        {repositories.ErrDuplicateLicenseNumber, http.StatusConflict, "duplicate_license_number"},
+        {services.ErrInvalidStatusTransition, http.StatusConflict, "invalid_status_transition"},
+        {services.ErrTrackingNotQueued, http.StatusServiceUnavailable, "tracking_not_queued"},
+        {fmt.Errorf("%w: unexpected EOF", ErrInvalidRequest), http.StatusBadRequest, "invalid_request"},
+        {ErrMethodNotAllowed, http.StatusMethodNotAllowed, codeMethodNotAllowed},
+        {
+            repositories.NewValidationError(
+                errors.New("vehicle name is required"),
+                repositories.FieldError{Field: "vehicle_name", Rule: "required"},
+            ),
+            http.StatusUnprocessableEntity,
+            codeValidationFailed,
+        },
+        {errors.New("connection reset"), http.StatusInternalServerError, codeInternalError},
+    }
+
+    for _, test := range tests {
+        status, detail := errorStatus(test.err)
+        if status != test.status {
+            t.Fatalf("%v should be %d, got %d", test.err, test.status, status)
+        }
+        if detail.Code != test.code {
+            t.Fatalf("%v should have the code %s, got %s", test.err, test.code, detail.Code)
+        }
+    }
+}
+
+func TestWriteError(t *testing.T) {
+    err := repositories.NewValidationError(
+        fmt.Errorf("%w: page must be a valid number", repositories.ErrInvalidQuery),
+        repositories.FieldError{Field: "page", Rule: "number", Message: "page must be a valid number"},
+    )
+
+    w := httptest.NewRecorder()
+    writeError(w, httptest.NewRequest(http.MethodGet, "/api/v1/vehicles?page=x", nil), err)
+
+    if w.Code != http.StatusBadRequest {
+        t.Fatalf("An invalid query parameter should be a bad request, got %d", w.Code)
+    }
+
+    var response struct {
+        Success bool        `json:"success"`
+        Error   ErrorDetail `json:"error"`
+    }
+    if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+        t.Fatal(err)
+    }
+    if response.Success || response.Error.Code != "invalid_query" {
+        t.Fatalf("Error code should be invalid_query, got %s", response.Error.Code)
+    }
+    if len(response.Error.Fields) != 1 || response.Error.Fields[0].Field != "page" {
+        t.Fatal("Error should list the invalid query parameter")
+    }
+}
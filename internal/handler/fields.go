@@ -0,0 +1,44 @@
+package handler
+
+import (
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+)
+
+// sparseVehicle keeps the selected fields of the vehicle's JSON, the repository only read those fields
+// (and the ones it needed, e.g. the ID) but the other fields of the model would still be encoded with zero values
+func sparseVehicle(vehicle *models.Vehicle, fields []string) (map[string]json.RawMessage, error) {
+    buf, err := json.Marshal(vehicle)
+    if err != nil {
+        return nil, err
+    }
+    var all map[string]json.RawMessage
+    if err := json.Unmarshal(buf, &all); err != nil {
+        return nil, err
+    }
+
+    sparse := make(map[string]json.RawMessage, len(fields))
+    for _, field := range fields {
+        // fields which are omitted when they are empty (e.g. deleted_at) stay omitted
+        if value, ok := all[field]; ok {
+            sparse[field] = value
+        }
+    }
+    return sparse, nil
+}
+
+// sparseVehicles returns the vehicles with only the selected fields, or the vehicles as they are without fields
+func sparseVehicles(vehicles []*models.Vehicle, fields []string) (any, error) {
+    if len(fields) == 0 {
+        return vehicles, nil
+    }
+    sparse := make([]map[string]json.RawMessage, 0, len(vehicles))
+    for _, vehicle := range vehicles {
+        selected, err := sparseVehicle(vehicle, fields)
+        if err != nil {
+            return nil, err
+        }
+        sparse = append(sparse, selected)
+    }
+    return sparse, nil
+}
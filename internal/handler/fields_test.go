@@ -0,0 +1,45 @@
+package handler
+
+import (
+    "testing"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSparseVehicles(t *testing.T) {
+    vehicle := models.NewVehicle().
+        SetVehicleName("Corolla").
+        SetVehicleModel("Toyota").
+        SetVehicleStatus(models.VehicleStatusActive)
+    vehicle.ID = primitive.NewObjectID()
+
+    data, err := sparseVehicles([]*models.Vehicle{vehicle}, []string{"id", "vehicle_name", "deleted_at"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    buf, err := json.Marshal(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var vehicles []map[string]any
+    if err := json.Unmarshal(buf, &vehicles); err != nil {
+        t.Fatal(err)
+    }
+    if len(vehicles) != 1 || len(vehicles[0]) != 2 {
+        t.Fatalf("Vehicle should only have the selected fields, got %s", buf)
+    }
+    if vehicles[0]["id"] != vehicle.ID.Hex() || vehicles[0]["vehicle_name"] != "Corolla" {
+        t.Fatalf("Selected fields should be kept, got %s", buf)
+    }
+
+    data, err = sparseVehicles([]*models.Vehicle{vehicle}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := data.([]*models.Vehicle); !ok {
+        t.Fatal("Vehicles should be returned as they are without fields")
+    }
+}
@@ -5,6 +5,19 @@ import "net/http"
 // VehicleHandler is an interface for handling vehicle related requests
 type VehicleHandler interface {
     HandleCreateAndFindVehicle(w http.ResponseWriter, r *http.Request)
+    HandleVehicleByID(w http.ResponseWriter, r *http.Request)
     FindVehicleByID(w http.ResponseWriter, r *http.Request)
+    UpdateVehicle(w http.ResponseWriter, r *http.Request)
+    PatchVehicle(w http.ResponseWriter, r *http.Request)
+    DeleteVehicle(w http.ResponseWriter, r *http.Request)
+    RestoreVehicle(w http.ResponseWriter, r *http.Request)
+    ResetMileage(w http.ResponseWriter, r *http.Request)
+    FindTrackingHistory(w http.ResponseWriter, r *http.Request)
     PublishTrackingData(w http.ResponseWriter, r *http.Request)
 }
+
+// HealthHandler is an interface for the liveness and readiness probes
+type HealthHandler interface {
+    Liveness(w http.ResponseWriter, r *http.Request)
+    Readiness(w http.ResponseWriter, r *http.Request)
+}
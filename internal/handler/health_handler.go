@@ -0,0 +1,131 @@
+package handler
+
+import (
+    "context"
+    "log/slog"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+)
+
+const (
+    HealthStatusUp   = "up"
+    HealthStatusDown = "down"
+
+    // healthCheckTimeout is how long a dependency may take to answer a readiness check
+    healthCheckTimeout = 2 * time.Second
+)
+
+// HealthCheck reports whether a dependency of the service is usable
+type HealthCheck func(ctx context.Context) error
+
+type DependencyStatus struct {
+    Status string `json:"status"`
+    Error  string `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+    Status       string                      `json:"status"`
+    Dependencies map[string]DependencyStatus `json:"dependencies,omitempty"`
+}
+
+type namedHealthCheck struct {
+    name  string
+    check HealthCheck
+}
+
+// V1HealthHandler answers the orchestrator's probes, it is not behind the authorization middleware
+type V1HealthHandler struct {
+    checks []namedHealthCheck
+}
+
+func NewV1HealthHandler() *V1HealthHandler {
+    return &V1HealthHandler{}
+}
+
+// AddCheck adds a dependency which has to be up for the service to be ready
+func (h *V1HealthHandler) AddCheck(name string, check HealthCheck) *V1HealthHandler {
+    h.checks = append(h.checks, namedHealthCheck{name: name, check: check})
+    return h
+}
+
+// Liveness reports that the process is running and serving requests, it doesn't check the dependencies,
+// so an unavailable database doesn't get the service restarted
+func (h *V1HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        writeError(w, r, ErrMethodNotAllowed)
+        return
+    }
+    h.writeReport(w, r, http.StatusOK, &HealthReport{Status: HealthStatusUp}, "service is alive")
+}
+
+// Readiness runs every check concurrently, it responds with 200 when all dependencies are up
+// and with 503 (so no traffic is routed to the service) when any of them is down
+func (h *V1HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        writeError(w, r, ErrMethodNotAllowed)
+        return
+    }
+
+    report := h.check(r.Context())
+    if report.Status != HealthStatusUp {
+        h.writeReport(w, r, http.StatusServiceUnavailable, report, "service is not ready")
+        return
+    }
+    h.writeReport(w, r, http.StatusOK, report, "service is ready")
+}
+
+func (h *V1HealthHandler) check(ctx context.Context) *HealthReport {
+    ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+    defer cancel()
+
+    report := &HealthReport{
+        Status:       HealthStatusUp,
+        Dependencies: make(map[string]DependencyStatus, len(h.checks)),
+    }
+
+    var (
+        mu sync.Mutex
+        wg sync.WaitGroup
+    )
+    for _, c := range h.checks {
+        wg.Add(1)
+        go func(c namedHealthCheck) {
+            defer wg.Done()
+            status := DependencyStatus{Status: HealthStatusUp}
+            if err := c.check(ctx); err != nil {
+                status = DependencyStatus{Status: HealthStatusDown, Error: err.Error()}
+            }
+
+            mu.Lock()
+            defer mu.Unlock()
+            report.Dependencies[c.name] = status
+            if status.Status != HealthStatusUp {
+                report.Status = HealthStatusDown
+            }
+        }(c)
+    }
+    wg.Wait()
+
+    return report
+}
+
+func (h *V1HealthHandler) writeReport(
+    w http.ResponseWriter,
+    r *http.Request,
+    code int,
+    report *HealthReport,
+    message string,
+) {
+    response := common.DefaultSuccessResponse(report, message)
+    response.Success = code == http.StatusOK
+
+    w.Header().Set(common.ContentType, common.ApplicationJSON)
+    w.WriteHeader(code)
+    if err := json.NewEncoder(w).Encode(response); err != nil {
+        slog.ErrorContext(r.Context(), "Failed to encode response", slog.Any("error", err))
+    }
+}
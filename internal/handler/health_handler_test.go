@@ -0,0 +1,81 @@
+package handler
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/goccy/go-json"
+)
+
+type healthResponse struct {
+    Success bool         `json:"success"`
+    Data    HealthReport `json:"data"`
+}
+
+func TestV1HealthHandler_Liveness(t *testing.T) {
+    h := NewV1HealthHandler().AddCheck(
+        "mongo", func(ctx context.Context) error {
+            return errors.New("connection refused")
+        },
+    )
+
+    w := httptest.NewRecorder()
+    h.Liveness(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+    if w.Code != http.StatusOK {
+        t.Fatalf("Liveness should not depend on the dependencies, got %d", w.Code)
+    }
+}
+
+func TestV1HealthHandler_Readiness(t *testing.T) {
+    h := NewV1HealthHandler().
+        AddCheck(
+            "mongo", func(ctx context.Context) error {
+                return nil
+            },
+        ).
+        AddCheck(
+            "rabbitmq", func(ctx context.Context) error {
+                return errors.New("connection is closed")
+            },
+        )
+
+    w := httptest.NewRecorder()
+    h.Readiness(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+    if w.Code != http.StatusServiceUnavailable {
+        t.Fatalf("Readiness should fail when a dependency is down, got %d", w.Code)
+    }
+
+    var response healthResponse
+    if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+        t.Fatal(err)
+    }
+    if response.Success || response.Data.Status != HealthStatusDown {
+        t.Fatal("Report should be down")
+    }
+    if response.Data.Dependencies["mongo"].Status != HealthStatusUp {
+        t.Fatal("Mongo should be up")
+    }
+    if response.Data.Dependencies["rabbitmq"].Error != "connection is closed" {
+        t.Fatal("Report should include the reason of the failed dependency")
+    }
+}
+
+func TestV1HealthHandler_ReadinessUp(t *testing.T) {
+    h := NewV1HealthHandler().AddCheck(
+        "mongo", func(ctx context.Context) error {
+            return nil
+        },
+    )
+
+    w := httptest.NewRecorder()
+    h.Readiness(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+    if w.Code != http.StatusOK {
+        t.Fatalf("Readiness should succeed when every dependency is up, got %d", w.Code)
+    }
+}
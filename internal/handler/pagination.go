@@ -0,0 +1,87 @@
+package handler
+
+import (
+    "fmt"
+    "net/url"
+    "strconv"
+    "strings"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+)
+
+// Pagination describes the listed page and tells the client how to get the pages around it,
+// a cursor is passed as the cursor query parameter and it is empty when there is no such page
+type Pagination struct {
+    // Page is omitted when the page was selected by a cursor
+    Page  int `json:"page,omitempty"`
+    Limit int `json:"limit"`
+    // Total is null when the matches weren't counted, TotalPages is null unless they were counted exactly
+    Total      *int64 `json:"total"`
+    TotalPages *int64 `json:"total_pages"`
+    // TotalEstimated is set when there are too many matches to count them exactly
+    TotalEstimated bool   `json:"total_estimated,omitempty"`
+    NextCursor     string `json:"next_cursor,omitempty"`
+    PrevCursor     string `json:"prev_cursor,omitempty"`
+}
+
+func newPagination(page *repositories.VehiclePage) *Pagination {
+    return &Pagination{
+        Page:           page.Page,
+        Limit:          page.PageSize,
+        Total:          page.Total,
+        TotalPages:     page.TotalPages(),
+        TotalEstimated: page.TotalEstimated,
+        NextCursor:     page.NextCursor,
+        PrevCursor:     page.PrevCursor,
+    }
+}
+
+// ListResponse is the response of a list, the pagination is next to the data so the data stays the list
+type ListResponse struct {
+    *common.Response
+    Pagination *Pagination `json:"pagination"`
+}
+
+// pageLinks is the Link header (RFC 8288) of the page, the links keep the query of the request
+// and only change how the page is selected. A page selected by a cursor links to the pages around it by cursors,
+// a numbered page by their numbers, first and last are always numbered pages
+func pageLinks(requestURL *url.URL, page *repositories.VehiclePage) string {
+    link := func(rel string, set func(query url.Values)) string {
+        query := requestURL.Query()
+        query.Del("page")
+        query.Del("cursor")
+        set(query)
+        return fmt.Sprintf(`<%s?%s>; rel="%s"`, requestURL.Path, query.Encode(), rel)
+    }
+    withCursor := func(cursor string) func(query url.Values) {
+        return func(query url.Values) {
+            query.Set("cursor", cursor)
+        }
+    }
+    withPage := func(number int64) func(query url.Values) {
+        return func(query url.Values) {
+            query.Set("page", strconv.FormatInt(number, 10))
+        }
+    }
+
+    var links []string
+    switch {
+    case page.Page == 0 && page.NextCursor != "":
+        links = append(links, link("next", withCursor(page.NextCursor)))
+    case page.Page > 0 && page.HasNext:
+        links = append(links, link("next", withPage(int64(page.Page)+1)))
+    }
+    switch {
+    case page.Page == 0 && page.PrevCursor != "":
+        links = append(links, link("prev", withCursor(page.PrevCursor)))
+    case page.Page > 1:
+        links = append(links, link("prev", withPage(int64(page.Page)-1)))
+    }
+    links = append(links, link("first", withPage(1)))
+    // the last page is unknown unless the total was counted exactly
+    if totalPages := page.TotalPages(); totalPages != nil && *totalPages > 0 {
+        links = append(links, link("last", withPage(*totalPages)))
+    }
+    return strings.Join(links, ", ")
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+    "net/url"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+)
+
+// total is the address of a page's total
+func total(count int64) *int64 {
+    return &count
+}
+
+func TestPageLinks(t *testing.T) {
+    requestURL, err := url.Parse("/api/v1/vehicles?limit=10&vehicle_status=active&page=2")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    links := pageLinks(
+        requestURL, &repositories.VehiclePage{
+            Page:       2,
+            PageSize:   10,
+            Total:      total(45),
+            HasNext:    true,
+            NextCursor: "next",
+            PrevCursor: "prev",
+        },
+    )
+
+    want := `</api/v1/vehicles?limit=10&page=3&vehicle_status=active>; rel="next", ` +
+        `</api/v1/vehicles?limit=10&page=1&vehicle_status=active>; rel="prev", ` +
+        `</api/v1/vehicles?limit=10&page=1&vehicle_status=active>; rel="first", ` +
+        `</api/v1/vehicles?limit=10&page=5&vehicle_status=active>; rel="last"`
+    if links != want {
+        t.Fatalf("Links should be %s, got %s", want, links)
+    }
+
+    requestURL, err = url.Parse("/api/v1/vehicles?limit=10&cursor=current")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    links = pageLinks(
+        requestURL, &repositories.VehiclePage{
+            PageSize:       10,
+            Total:          total(10000),
+            TotalEstimated: true,
+            PrevCursor:     "prev",
+        },
+    )
+
+    want = `</api/v1/vehicles?cursor=prev&limit=10>; rel="prev", ` +
+        `</api/v1/vehicles?limit=10&page=1>; rel="first"`
+    if links != want {
+        t.Fatalf("Links should be %s, got %s", want, links)
+    }
+}
+
+func TestNewPagination(t *testing.T) {
+    pagination := newPagination(&repositories.VehiclePage{Page: 3, PageSize: 20, Total: total(41)})
+
+    if *pagination.TotalPages != 3 || pagination.Limit != 20 || pagination.Page != 3 {
+        t.Fatalf("Pagination should be page 3 of 3, got %+v", pagination)
+    }
+    if *newPagination(&repositories.VehiclePage{PageSize: 20, Total: total(0)}).TotalPages != 0 {
+        t.Fatal("Empty list should have no pages")
+    }
+    estimated := newPagination(&repositories.VehiclePage{PageSize: 20, Total: total(10000), TotalEstimated: true})
+    if estimated.TotalPages != nil {
+        t.Fatal("Estimated total should have no total pages")
+    }
+    if pagination := newPagination(&repositories.VehiclePage{PageSize: 20}); pagination.TotalPages != nil {
+        t.Fatal("Page which wasn't counted should have no total pages")
+    }
+}
@@ -1,41 +1,44 @@
 package handler
 
 import (
-    "errors"
     "fmt"
-    "log"
+    "log/slog"
     "net/http"
     "strings"
 
-    "github.com/go-playground/validator/v10"
     "github.com/goccy/go-json"
     "github.com/yemyoaung/managing-vehicle-tracking-common"
     "github.com/yemyoaung/managing-vehicle-tracking-models"
     "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/validation"
 )
 
-var (
-    ErrMethodNotAllowed = errors.New("method was not allowed")
-    ErrNotFound         = errors.New("not found")
-    ErrInvalidRequest   = errors.New("invalid request")
+const (
+    // IdempotencyKey lets the client retry publishing tracking data without it being applied twice
+    IdempotencyKey = "Idempotency-Key"
 )
 
 type V1TrackingHandler struct {
     vehicleService services.VehicleService
-    validate       *validator.Validate
+    translator     *validation.Translator
 }
 
-func NewV1VehicleHandler(vehicleService services.VehicleService, validate *validator.Validate) *V1TrackingHandler {
-    return &V1TrackingHandler{vehicleService: vehicleService, validate: validate}
+func NewV1VehicleHandler(vehicleService services.VehicleService, translator *validation.Translator) *V1TrackingHandler {
+    return &V1TrackingHandler{vehicleService: vehicleService, translator: translator}
 }
 
-func (h *V1TrackingHandler) methodWasNotAllowed(w http.ResponseWriter) {
-    common.HandleError(http.StatusMethodNotAllowed, w, ErrMethodNotAllowed)
+// validateRequest validates the request body, the messages of the invalid fields are in the client's language
+func (h *V1TrackingHandler) validateRequest(r *http.Request, req any) error {
+    return h.translator.Struct(req, validation.AcceptLanguages(r.Header.Get("Accept-Language"))...)
+}
+
+func (h *V1TrackingHandler) methodWasNotAllowed(w http.ResponseWriter, r *http.Request) {
+    writeError(w, r, ErrMethodNotAllowed)
 }
 
 func (h *V1TrackingHandler) HandleCreateAndFindVehicle(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet && r.Method != http.MethodPost {
-        h.methodWasNotAllowed(w)
+        h.methodWasNotAllowed(w, r)
         return
     }
     if r.Method == http.MethodPost {
@@ -49,13 +52,13 @@ func (h *V1TrackingHandler) CreateVehicle(w http.ResponseWriter, r *http.Request
     var req services.VehicleRequest
     if body, ok := r.Context().Value(common.Body).([]byte); ok {
         if err := json.Unmarshal(body, &req); err != nil {
-            common.HandleError(http.StatusUnprocessableEntity, w, err)
+            writeError(w, r, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
             return
         }
     }
 
-    if err := h.validate.Struct(&req); err != nil {
-        common.HandleError(http.StatusUnprocessableEntity, w, err)
+    if err := h.validateRequest(r, &req); err != nil {
+        writeError(w, r, err)
         return
     }
 
@@ -67,7 +70,7 @@ func (h *V1TrackingHandler) CreateVehicle(w http.ResponseWriter, r *http.Request
     // }
     vehicle, err := h.vehicleService.CreateVehicle(r.Context(), &req)
     if err != nil {
-        common.HandleError(http.StatusUnprocessableEntity, w, err)
+        writeError(w, r, err)
         return
     }
 
@@ -77,69 +80,332 @@ func (h *V1TrackingHandler) CreateVehicle(w http.ResponseWriter, r *http.Request
             "successfully created vehicle",
         ),
     ); err != nil {
-        log.Printf("Failed to encode response: %v", err)
+        slog.ErrorContext(r.Context(), "Failed to encode response", slog.Any("error", err))
     }
 }
 
 func (h *V1TrackingHandler) FindVehicles(w http.ResponseWriter, r *http.Request) {
-    vehicles, err := h.vehicleService.FindVehicles(r.Context(), r.URL.Query())
+    page, err := h.vehicleService.FindVehicles(r.Context(), r.URL.Query())
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    // no matching vehicles is an empty list, not an error
+    vehicles := page.Vehicles
+    if vehicles == nil {
+        vehicles = []*models.Vehicle{}
+    }
+
+    data, err := sparseVehicles(vehicles, services.ParseFields(r.URL.Query().Get("fields")))
     if err != nil {
-        common.HandleError(http.StatusBadRequest, w, err)
+        writeError(w, r, err)
         return
     }
 
-    if len(vehicles) == 0 {
-        common.HandleError(http.StatusNotFound, w, ErrNotFound)
+    if links := pageLinks(r.URL, page); links != "" {
+        w.Header().Set("Link", links)
+    }
+
+    response := ListResponse{
+        Response:   common.DefaultSuccessResponse(data, "successfully fetched vehicles"),
+        Pagination: newPagination(page),
+    }
+    if err = json.NewEncoder(w).Encode(response); err != nil {
+        slog.ErrorContext(r.Context(), "Failed to encode response", slog.Any("error", err))
+    }
+}
+
+// vehicleIDFromPath returns the ID from "/api/v1/vehicles/:id"
+func vehicleIDFromPath(path string) (string, bool) {
+    segments := strings.Split(path, "/")
+
+    // path is "/api/v1/vehicles/:id", the ID should be in the fifth segment
+    if len(segments) < 5 || segments[4] == "" {
+        return "", false
+    }
+    return segments[4], true
+}
+
+// vehicleSubResourceFromPath returns the resource from "/api/v1/vehicles/:id/:resource"
+func vehicleSubResourceFromPath(path string) string {
+    segments := strings.Split(path, "/")
+    if len(segments) < 6 {
+        return ""
+    }
+    return segments[5]
+}
+
+func (h *V1TrackingHandler) HandleVehicleByID(w http.ResponseWriter, r *http.Request) {
+    switch vehicleSubResourceFromPath(r.URL.Path) {
+    case "":
+    case "restore":
+        h.RestoreVehicle(w, r)
+        return
+    case "mileage-reset":
+        h.ResetMileage(w, r)
+        return
+    case "tracking":
+        h.FindTrackingHistory(w, r)
+        return
+    default:
+        writeError(w, r, ErrNotFound)
         return
     }
 
-    if err = json.NewEncoder(w).Encode(common.DefaultSuccessResponse(vehicles, "successfully fetched vehicles"));
-        err != nil {
-        log.Printf("Failed to encode response: %v", err)
+    switch r.Method {
+    case http.MethodGet:
+        h.FindVehicleByID(w, r)
+    case http.MethodPut:
+        h.UpdateVehicle(w, r)
+    case http.MethodPatch:
+        h.PatchVehicle(w, r)
+    case http.MethodDelete:
+        h.DeleteVehicle(w, r)
+    default:
+        h.methodWasNotAllowed(w, r)
     }
 }
 
 func (h *V1TrackingHandler) FindVehicleByID(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet {
-        h.methodWasNotAllowed(w)
+        h.methodWasNotAllowed(w, r)
         return
     }
 
-    path := r.URL.Path
-    segments := strings.Split(path, "/")
+    id, ok := vehicleIDFromPath(r.URL.Path)
+    if !ok {
+        writeError(w, r, ErrNotFound)
+        return
+    }
 
-    // path is "/api/v1/vehicles/:id", the ID should be in the fifth segment
-    if len(segments) < 5 {
-        http.NotFound(w, r)
+    fields := services.ParseFields(r.URL.Query().Get("fields"))
+    vehicle, err := h.vehicleService.GetVehicleByID(r.Context(), id, fields...)
+
+    if err != nil {
+        writeError(w, r, err)
         return
     }
 
-    vehicle, err := h.vehicleService.GetVehicleByID(r.Context(), segments[4])
+    var data any = vehicle
+    if len(fields) > 0 {
+        if data, err = sparseVehicle(vehicle, fields); err != nil {
+            writeError(w, r, err)
+            return
+        }
+    }
+
+    err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            data,
+            fmt.Sprintf("successfully fetched vehicle with ID: %s", id),
+        ),
+    )
 
     if err != nil {
-        w.WriteHeader(http.StatusNotFound)
-        if err := json.NewEncoder(w).Encode(common.DefaultErrorResponse(err)); err != nil {
-            log.Printf("Failed to encode response: %v", err)
+        slog.ErrorContext(r.Context(), "Failed to encode response", slog.Any("error", err))
+    }
+
+}
+
+func (h *V1TrackingHandler) UpdateVehicle(w http.ResponseWriter, r *http.Request) {
+    id, ok := vehicleIDFromPath(r.URL.Path)
+    if !ok {
+        writeError(w, r, ErrNotFound)
+        return
+    }
+
+    var req services.VehicleRequest
+    if body, ok := r.Context().Value(common.Body).([]byte); ok {
+        if err := json.Unmarshal(body, &req); err != nil {
+            writeError(w, r, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+            return
         }
+    }
+
+    h.updateVehicle(w, r, id, &req)
+}
+
+func (h *V1TrackingHandler) PatchVehicle(w http.ResponseWriter, r *http.Request) {
+    id, ok := vehicleIDFromPath(r.URL.Path)
+    if !ok {
+        writeError(w, r, ErrNotFound)
         return
     }
 
-    err = json.NewEncoder(w).Encode(
+    body, ok := r.Context().Value(common.Body).([]byte)
+    if !ok {
+        writeError(w, r, ErrInvalidRequest)
+        return
+    }
+
+    vehicle, err := h.vehicleService.GetVehicleByID(r.Context(), id)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    req, err := services.MergeVehiclePatch(vehicle, body)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    h.updateVehicle(w, r, id, req)
+}
+
+// updateVehicle validates the request and stores it, it is shared by PUT and PATCH
+func (h *V1TrackingHandler) updateVehicle(
+    w http.ResponseWriter,
+    r *http.Request,
+    id string,
+    req *services.VehicleRequest,
+) {
+    if err := h.validateRequest(r, req); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    vehicle, err := h.vehicleService.UpdateVehicle(r.Context(), id, req)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
         common.DefaultSuccessResponse(
             vehicle,
-            fmt.Sprintf("successfully fetched vehicle with ID: %s", segments[4]),
+            fmt.Sprintf("successfully updated vehicle with ID: %s", id),
         ),
-    )
+    ); err != nil {
+        slog.ErrorContext(r.Context(), "Failed to encode response", slog.Any("error", err))
+    }
+}
+
+func (h *V1TrackingHandler) DeleteVehicle(w http.ResponseWriter, r *http.Request) {
+    id, ok := vehicleIDFromPath(r.URL.Path)
+    if !ok {
+        writeError(w, r, ErrNotFound)
+        return
+    }
+
+    if err := h.vehicleService.DeleteVehicle(r.Context(), id); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            nil,
+            fmt.Sprintf("successfully archived vehicle with ID: %s", id),
+        ),
+    ); err != nil {
+        slog.ErrorContext(r.Context(), "Failed to encode response", slog.Any("error", err))
+    }
+}
+
+func (h *V1TrackingHandler) RestoreVehicle(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        h.methodWasNotAllowed(w, r)
+        return
+    }
+
+    id, ok := vehicleIDFromPath(r.URL.Path)
+    if !ok {
+        writeError(w, r, ErrNotFound)
+        return
+    }
 
+    vehicle, err := h.vehicleService.RestoreVehicle(r.Context(), id)
     if err != nil {
-        log.Printf("Failed to encode response: %v", err)
+        writeError(w, r, err)
+        return
     }
 
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            vehicle,
+            fmt.Sprintf("successfully restored vehicle with ID: %s", id),
+        ),
+    ); err != nil {
+        slog.ErrorContext(r.Context(), "Failed to encode response", slog.Any("error", err))
+    }
+}
+
+// ResetMileage starts a new mileage baseline after the odometer was replaced or rolled over,
+// the tracking readings which are lower than the stored mileage are rejected until then
+func (h *V1TrackingHandler) ResetMileage(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        h.methodWasNotAllowed(w, r)
+        return
+    }
+
+    id, ok := vehicleIDFromPath(r.URL.Path)
+    if !ok {
+        writeError(w, r, ErrNotFound)
+        return
+    }
+
+    var req services.MileageResetRequest
+    if body, ok := r.Context().Value(common.Body).([]byte); ok {
+        if err := json.Unmarshal(body, &req); err != nil {
+            writeError(w, r, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+            return
+        }
+    }
+
+    if err := h.validateRequest(r, &req); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    vehicle, err := h.vehicleService.ResetMileage(r.Context(), id, &req)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            vehicle,
+            fmt.Sprintf("successfully reset mileage of vehicle with ID: %s", id),
+        ),
+    ); err != nil {
+        slog.ErrorContext(r.Context(), "Failed to encode response", slog.Any("error", err))
+    }
+}
+
+func (h *V1TrackingHandler) FindTrackingHistory(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        h.methodWasNotAllowed(w, r)
+        return
+    }
+
+    id, ok := vehicleIDFromPath(r.URL.Path)
+    if !ok {
+        writeError(w, r, ErrNotFound)
+        return
+    }
+
+    history, err := h.vehicleService.FindTrackingHistory(r.Context(), id, r.URL.Query())
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            history,
+            fmt.Sprintf("successfully fetched tracking history of vehicle with ID: %s", id),
+        ),
+    ); err != nil {
+        slog.ErrorContext(r.Context(), "Failed to encode response", slog.Any("error", err))
+    }
 }
 
 func (h *V1TrackingHandler) PublishTrackingData(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodPost {
-        h.methodWasNotAllowed(w)
+        h.methodWasNotAllowed(w, r)
         return
     }
 
@@ -147,15 +413,16 @@ func (h *V1TrackingHandler) PublishTrackingData(w http.ResponseWriter, r *http.R
 
     if body, ok := r.Context().Value(common.Body).([]byte); ok {
         if err := json.Unmarshal(body, &req); err != nil {
-            common.HandleError(http.StatusUnprocessableEntity, w, err)
+            writeError(w, r, fmt.Errorf("%w: %w", ErrInvalidRequest, err))
             return
         }
     } else {
-        common.HandleError(http.StatusBadRequest, w, ErrInvalidRequest)
+        writeError(w, r, ErrInvalidRequest)
+        return
     }
 
-    if err := h.validate.Struct(&req); err != nil {
-        common.HandleError(http.StatusUnprocessableEntity, w, err)
+    if err := h.validateRequest(r, &req); err != nil {
+        writeError(w, r, err)
         return
     }
 
@@ -165,16 +432,23 @@ func (h *V1TrackingHandler) PublishTrackingData(w http.ResponseWriter, r *http.R
     // if ok {
     //     log.Println("User: ", user)
     // }
-    err := h.vehicleService.PublishTrackingData(r.Context(), &req)
+    messageID, err := h.vehicleService.PublishTrackingData(r.Context(), r.Header.Get(IdempotencyKey), &req)
 
     if err != nil {
-        common.HandleError(http.StatusUnprocessableEntity, w, err)
+        // when the message wasn't stored (503), the client can safely retry with the same idempotency key
+        writeError(w, r, err)
         return
     }
 
-    if err = json.NewEncoder(w).Encode(common.DefaultSuccessResponse(nil, "successfully published tracking data"));
-        err != nil {
-        log.Printf("Failed to encode response: %v", err)
+    // the tracking data is published asynchronously by the outbox relay
+    w.WriteHeader(http.StatusAccepted)
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            map[string]string{"message_id": messageID},
+            "successfully queued tracking data",
+        ),
+    ); err != nil {
+        slog.ErrorContext(r.Context(), "Failed to encode response", slog.Any("error", err))
     }
 
 }
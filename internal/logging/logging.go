@@ -0,0 +1,99 @@
+package logging
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "strings"
+)
+
+const (
+    FormatJSON = "json"
+    FormatText = "text"
+)
+
+var (
+    ErrUnknownLevel  = errors.New("unknown log level")
+    ErrUnknownFormat = errors.New("unknown log format")
+)
+
+type contextKey string
+
+const (
+    requestIDKey contextKey = "request_id"
+    messageIDKey contextKey = "message_id"
+)
+
+// NewLogger creates a logger which writes level and above in the given format (json or text),
+// the request and message IDs of the context are added to every record logged with a context
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+    var lvl slog.Level
+    if err := lvl.UnmarshalText([]byte(level)); err != nil {
+        return nil, fmt.Errorf("%w: %s", ErrUnknownLevel, level)
+    }
+
+    options := &slog.HandlerOptions{Level: lvl}
+    var handler slog.Handler
+    switch strings.ToLower(format) {
+    case FormatJSON:
+        handler = slog.NewJSONHandler(w, options)
+    case FormatText:
+        handler = slog.NewTextHandler(w, options)
+    default:
+        return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+    }
+
+    return slog.New(&contextHandler{Handler: handler}), nil
+}
+
+// contextHandler adds the correlation IDs found in the context to the records
+type contextHandler struct {
+    slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+    if requestID := RequestID(ctx); requestID != "" {
+        record.AddAttrs(slog.String(string(requestIDKey), requestID))
+    }
+    if messageID := MessageID(ctx); messageID != "" {
+        record.AddAttrs(slog.String(string(messageIDKey), messageID))
+    }
+    return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+    return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// WithRequestID returns ctx carrying the ID of the request, it is also the correlation ID of the
+// tracking messages the request queued
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+    if requestID == "" {
+        return ctx
+    }
+    return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+func RequestID(ctx context.Context) string {
+    requestID, _ := ctx.Value(requestIDKey).(string)
+    return requestID
+}
+
+// WithMessageID returns ctx carrying the ID of the tracking message which is being processed
+func WithMessageID(ctx context.Context, messageID string) context.Context {
+    if messageID == "" {
+        return ctx
+    }
+    return context.WithValue(ctx, messageIDKey, messageID)
+}
+
+func MessageID(ctx context.Context) string {
+    messageID, _ := ctx.Value(messageIDKey).(string)
+    return messageID
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/goccy/go-json"
+)
+
+func TestNewLogger(t *testing.T) {
+    if _, err := NewLogger(&bytes.Buffer{}, "verbose", FormatJSON); !errors.Is(err, ErrUnknownLevel) {
+        t.Fatal("Unknown level should be rejected")
+    }
+    if _, err := NewLogger(&bytes.Buffer{}, "info", "xml"); !errors.Is(err, ErrUnknownFormat) {
+        t.Fatal("Unknown format should be rejected")
+    }
+
+    var buf bytes.Buffer
+    logger, err := NewLogger(&buf, "warn", FormatJSON)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    logger.Info("Not logged")
+    if buf.Len() != 0 {
+        t.Fatal("Records below the level should be dropped")
+    }
+
+    ctx := WithMessageID(WithRequestID(context.Background(), "request"), "message")
+    logger.WarnContext(ctx, "Logged")
+
+    var record map[string]any
+    if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+        t.Fatal(err)
+    }
+    if record["request_id"] != "request" || record["message_id"] != "message" {
+        t.Fatalf("Record should carry the correlation IDs, got %v", record)
+    }
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+    var requestID string
+    handler := RequestIDMiddleware(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                requestID = RequestID(r.Context())
+            },
+        ),
+    )
+
+    w := httptest.NewRecorder()
+    handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+    if requestID == "" || w.Header().Get(RequestIDHeader) != requestID {
+        t.Fatal("Generated request ID should be passed on and echoed")
+    }
+
+    r := httptest.NewRequest(http.MethodGet, "/", nil)
+    r.Header.Set(RequestIDHeader, "abc")
+    w = httptest.NewRecorder()
+    handler.ServeHTTP(w, r)
+    if requestID != "abc" || w.Header().Get(RequestIDHeader) != "abc" {
+        t.Fatal("Request ID of the caller should be kept")
+    }
+}
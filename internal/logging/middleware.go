@@ -0,0 +1,67 @@
+package logging
+
+import (
+    "log/slog"
+    "net/http"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    // RequestIDHeader is read from the request (if the caller already has an ID) and echoed in the response
+    RequestIDHeader = "X-Request-ID"
+
+    // maxRequestIDLength keeps a client from filling the logs with its request IDs
+    maxRequestIDLength = 128
+)
+
+// RequestIDMiddleware gives every request an ID, it is echoed in the response
+// and carried by the request's context, so the logs of the request (and of its tracking data) can be found
+func RequestIDMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(
+        func(w http.ResponseWriter, r *http.Request) {
+            requestID := r.Header.Get(RequestIDHeader)
+            if requestID == "" || len(requestID) > maxRequestIDLength {
+                requestID = primitive.NewObjectID().Hex()
+            }
+
+            w.Header().Set(RequestIDHeader, requestID)
+            next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), requestID)))
+        },
+    )
+}
+
+// statusRecorder remembers the status code written by the handler
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+    r.status = status
+    r.ResponseWriter.WriteHeader(status)
+}
+
+// AccessLogMiddleware logs every request after it was handled
+func AccessLogMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                start := time.Now()
+                recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+                next.ServeHTTP(recorder, r)
+
+                logger.InfoContext(
+                    r.Context(),
+                    "Handled request",
+                    slog.String("method", r.Method),
+                    slog.String("path", r.URL.Path),
+                    slog.Int("status", recorder.status),
+                    slog.Duration("duration", time.Since(start)),
+                )
+            },
+        )
+    }
+}
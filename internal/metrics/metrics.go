@@ -0,0 +1,115 @@
+package metrics
+
+import (
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promauto"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "vehicle"
+
+// the outcomes of a consumed tracking message, every message is counted once by how it ended (ack, nack or dead_letter)
+// and once more for every retry
+const (
+    OutcomeAck        = "ack"
+    OutcomeNack       = "nack"
+    OutcomeRetry      = "retry"
+    OutcomeDeadLetter = "dead_letter"
+)
+
+var (
+    HTTPRequestDuration = promauto.NewHistogramVec(
+        prometheus.HistogramOpts{
+            Namespace: namespace,
+            Name:      "http_request_duration_seconds",
+            Help:      "Duration of the HTTP requests by route, method and status code.",
+            Buckets:   prometheus.DefBuckets,
+        },
+        []string{"route", "method", "code"},
+    )
+
+    ConsumedMessages = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "consumer_messages_total",
+            Help:      "Tracking messages consumed by outcome (ack, nack or dead_letter) and their retries (retry).",
+        },
+        []string{"outcome"},
+    )
+
+    PublishedMessages = promauto.NewCounterVec(
+        prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "publisher_messages_total",
+            Help:      "Tracking messages published by result (success, nacked, returned or unconfirmed).",
+        },
+        []string{"result"},
+    )
+
+    OutboxFailedMessages = promauto.NewCounter(
+        prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "outbox_failed_messages_total",
+            Help:      "Tracking messages the outbox relay gave up on after running out of attempts.",
+        },
+    )
+
+    MongoOperationDuration = promauto.NewHistogramVec(
+        prometheus.HistogramOpts{
+            Namespace: namespace,
+            Name:      "mongo_operation_duration_seconds",
+            Help:      "Duration of the MongoDB operations of the vehicle repository.",
+            Buckets:   prometheus.DefBuckets,
+        },
+        []string{"operation"},
+    )
+)
+
+// InstrumentRoute records the duration of the route's requests
+func InstrumentRoute(route string, handler http.HandlerFunc) http.HandlerFunc {
+    return promhttp.InstrumentHandlerDuration(
+        HTTPRequestDuration.MustCurryWith(prometheus.Labels{"route": route}),
+        handler,
+    )
+}
+
+// ObserveMongoOperation records the time since start, it is meant to be deferred
+func ObserveMongoOperation(operation string, start time.Time) {
+    MongoOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+var (
+    // outboxBacklog is the function the backlog gauge reads, it is replaced by every RegisterOutboxBacklog
+    outboxBacklog         atomic.Pointer[func() float64]
+    registerOutboxBacklog sync.Once
+)
+
+// RegisterOutboxBacklog exposes the number of tracking messages waiting in the outbox,
+// the gauge is registered once and calling it again (e.g. the app is started again) only replaces the backlog function
+func RegisterOutboxBacklog(backlog func() float64) {
+    outboxBacklog.Store(&backlog)
+    registerOutboxBacklog.Do(
+        func() {
+            promauto.NewGaugeFunc(
+                prometheus.GaugeOpts{
+                    Namespace: namespace,
+                    Name:      "outbox_backlog",
+                    Help:      "Tracking messages waiting in the outbox to be published.",
+                },
+                func() float64 {
+                    return (*outboxBacklog.Load())()
+                },
+            )
+        },
+    )
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+    return promhttp.Handler()
+}
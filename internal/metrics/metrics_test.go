@@ -0,0 +1,38 @@
+package metrics
+
+import (
+    "testing"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterOutboxBacklog(t *testing.T) {
+    RegisterOutboxBacklog(
+        func() float64 {
+            return 1
+        },
+    )
+    // registering again must not panic with a duplicate collector, the gauge reads the latest function
+    RegisterOutboxBacklog(
+        func() float64 {
+            return 2
+        },
+    )
+
+    families, err := prometheus.DefaultGatherer.Gather()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, family := range families {
+        if family.GetName() != "vehicle_outbox_backlog" {
+            continue
+        }
+        if len(family.GetMetric()) != 1 || family.GetMetric()[0].GetGauge().GetValue() != 2 {
+            t.Fatal("Backlog gauge should read the latest function")
+        }
+        return
+    }
+    t.Fatal("Backlog gauge should be registered")
+}
@@ -0,0 +1,66 @@
+package repositories
+
+import (
+    "strings"
+)
+
+// ErrorKind classifies the domain errors, the HTTP layer maps every kind to a single status code
+type ErrorKind string
+
+const (
+    KindNotFound        ErrorKind = "not_found"
+    KindConflict        ErrorKind = "conflict"
+    KindInvalidID       ErrorKind = "invalid_id"
+    KindInvalidArgument ErrorKind = "invalid_argument"
+    KindUnavailable     ErrorKind = "unavailable"
+)
+
+// Error is a domain error, Code is the machine-readable reason (e.g. vehicle_not_found) returned to the client.
+// The errors are compared with errors.Is, so they can be wrapped with more details
+type Error struct {
+    Kind    ErrorKind
+    Code    string
+    message string
+}
+
+func NewError(kind ErrorKind, code, message string) *Error {
+    return &Error{Kind: kind, Code: code, message: message}
+}
+
+func (e *Error) Error() string {
+    return e.message
+}
+
+// FieldError tells why a single field is invalid, Field is the JSON name of the field (or the query parameter)
+// and Rule is the rule it broke (e.g. required)
+type FieldError struct {
+    Field   string `json:"field"`
+    Rule    string `json:"rule"`
+    Message string `json:"message"`
+}
+
+// ValidationError is returned when the input is invalid, it lists the invalid fields.
+// Err is the underlying error, it can be a domain error which decides the kind (e.g. ErrInvalidQuery)
+type ValidationError struct {
+    Fields []FieldError
+    Err    error
+}
+
+func NewValidationError(err error, fields ...FieldError) *ValidationError {
+    return &ValidationError{Fields: fields, Err: err}
+}
+
+func (e *ValidationError) Error() string {
+    if e.Err != nil {
+        return e.Err.Error()
+    }
+    messages := make([]string, 0, len(e.Fields))
+    for _, field := range e.Fields {
+        messages = append(messages, field.Message)
+    }
+    return strings.Join(messages, ", ")
+}
+
+func (e *ValidationError) Unwrap() error {
+    return e.Err
+}
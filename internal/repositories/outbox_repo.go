@@ -0,0 +1,215 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/logging"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/propagation"
+)
+
+const (
+    outboxCollection = "tracking_outbox"
+    // sentOutboxRetention is how long sent messages are kept before the TTL index removes them
+    sentOutboxRetention = 7 * 24 * time.Hour
+)
+
+type OutboxStatus string
+
+const (
+    OutboxStatusPending OutboxStatus = "pending"
+    OutboxStatusSent    OutboxStatus = "sent"
+    // OutboxStatusFailed is a message which ran out of attempts, it is kept for inspection
+    // and is published again once its status is set back to pending
+    OutboxStatusFailed OutboxStatus = "failed"
+)
+
+// OutboxMessage is a tracking message waiting to be published by the outbox relay
+type OutboxMessage struct {
+    ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+    MessageID string             `json:"message_id" bson:"message_id"`
+    Payload   []byte             `json:"payload" bson:"payload"`
+    Status    OutboxStatus       `json:"status" bson:"status"`
+    Attempts  int                `json:"attempts" bson:"attempts"`
+    LastError string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+    // NextAttemptAt is when the message can be claimed, claiming it moves it forward by the lease
+    // so a relay which dies while publishing doesn't keep the message forever
+    NextAttemptAt time.Time  `json:"next_attempt_at" bson:"next_attempt_at"`
+    CreatedAt     time.Time  `json:"created_at" bson:"created_at"`
+    SentAt        *time.Time `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+    FailedAt      *time.Time `json:"failed_at,omitempty" bson:"failed_at,omitempty"`
+    // TraceContext is the trace context of the request which queued the message,
+    // the relay publishes the message within that trace
+    TraceContext map[string]string `json:"trace_context,omitempty" bson:"trace_context,omitempty"`
+    // CorrelationID is the ID of the request which queued the message, it is published as the correlation ID
+    CorrelationID string `json:"correlation_id,omitempty" bson:"correlation_id,omitempty"`
+}
+
+type OutboxRepository interface {
+    AddMessage(ctx context.Context, messageID string, payload []byte) error
+    ClaimPending(ctx context.Context, lease time.Duration) (*OutboxMessage, error)
+    MarkSent(ctx context.Context, id primitive.ObjectID) error
+    MarkFailed(ctx context.Context, id primitive.ObjectID, reason string, nextAttemptAt time.Time) error
+    MarkUndeliverable(ctx context.Context, id primitive.ObjectID, reason string) error
+    CountPending(ctx context.Context) (int64, error)
+}
+
+type MongoOutboxRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoOutboxRepository(ctx context.Context, db *mongo.Database) (*MongoOutboxRepository, error) {
+    collection := db.Collection(outboxCollection)
+
+    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+    defer cancel()
+
+    _, err := collection.Indexes().CreateMany(
+        ctx, []mongo.IndexModel{
+            {
+                // the pending messages are claimed in the order they were queued
+                Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}},
+            },
+            {
+                // the same message ID (e.g. a retried request with an idempotency key) is only queued once
+                Keys:    bson.M{"message_id": 1},
+                Options: options.Index().SetUnique(true),
+            },
+            {
+                // sent_at only exists on sent messages, so pending ones never expire
+                Keys:    bson.M{"sent_at": 1},
+                Options: options.Index().SetExpireAfterSeconds(int32(sentOutboxRetention.Seconds())),
+            },
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    return &MongoOutboxRepository{
+        collection: collection,
+    }, nil
+}
+
+// AddMessage queues the message together with the trace context and the request ID of ctx,
+// queueing a message ID which is already in the outbox is not an error
+func (repo *MongoOutboxRepository) AddMessage(ctx context.Context, messageID string, payload []byte) error {
+    traceContext := propagation.MapCarrier{}
+    otel.GetTextMapPropagator().Inject(ctx, traceContext)
+
+    now := time.Now()
+    _, err := repo.collection.InsertOne(
+        ctx, OutboxMessage{
+            MessageID:     messageID,
+            Payload:       payload,
+            Status:        OutboxStatusPending,
+            NextAttemptAt: now,
+            CreatedAt:     now,
+            TraceContext:  traceContext,
+            CorrelationID: logging.RequestID(ctx),
+        },
+    )
+    if err != nil && !mongo.IsDuplicateKeyError(err) {
+        return err
+    }
+    return nil
+}
+
+// ClaimPending leases the oldest pending message to the caller if it is due,
+// it returns nil when there is nothing to publish or the oldest message is still backing off (or leased),
+// so the messages are published in the order they were queued and a failed message is never overtaken
+func (repo *MongoOutboxRepository) ClaimPending(ctx context.Context, lease time.Duration) (*OutboxMessage, error) {
+    var oldest OutboxMessage
+    err := repo.collection.FindOne(
+        ctx,
+        bson.M{"status": OutboxStatusPending},
+        options.FindOne().
+            SetSort(bson.D{{Key: "_id", Value: 1}}).
+            SetProjection(bson.M{"_id": 1, "next_attempt_at": 1}),
+    ).Decode(&oldest)
+    if err != nil {
+        if errors.Is(err, mongo.ErrNoDocuments) {
+            return nil, nil
+        }
+        return nil, err
+    }
+    now := time.Now()
+    if oldest.NextAttemptAt.After(now) {
+        return nil, nil
+    }
+
+    // another relay may have claimed it in the meantime, then there is nothing to publish for this one
+    var message OutboxMessage
+    err = repo.collection.FindOneAndUpdate(
+        ctx,
+        bson.M{
+            "_id":             oldest.ID,
+            "status":          OutboxStatusPending,
+            "next_attempt_at": bson.M{"$lte": now},
+        },
+        bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}},
+        options.FindOneAndUpdate().SetReturnDocument(options.After),
+    ).Decode(&message)
+    if err != nil {
+        if errors.Is(err, mongo.ErrNoDocuments) {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return &message, nil
+}
+
+func (repo *MongoOutboxRepository) MarkSent(ctx context.Context, id primitive.ObjectID) error {
+    _, err := repo.collection.UpdateByID(
+        ctx,
+        id,
+        bson.M{
+            "$set":   bson.M{"status": OutboxStatusSent, "sent_at": time.Now()},
+            "$inc":   bson.M{"attempts": 1},
+            "$unset": bson.M{"last_error": ""},
+        },
+    )
+    return err
+}
+
+// MarkFailed keeps the message pending and schedules the next attempt
+func (repo *MongoOutboxRepository) MarkFailed(
+    ctx context.Context,
+    id primitive.ObjectID,
+    reason string,
+    nextAttemptAt time.Time,
+) error {
+    _, err := repo.collection.UpdateByID(
+        ctx,
+        id,
+        bson.M{
+            "$set": bson.M{"last_error": reason, "next_attempt_at": nextAttemptAt},
+            "$inc": bson.M{"attempts": 1},
+        },
+    )
+    return err
+}
+
+// MarkUndeliverable gives up on the message, it is marked as failed so the relay moves on to the next one
+func (repo *MongoOutboxRepository) MarkUndeliverable(ctx context.Context, id primitive.ObjectID, reason string) error {
+    _, err := repo.collection.UpdateByID(
+        ctx,
+        id,
+        bson.M{
+            "$set": bson.M{"status": OutboxStatusFailed, "last_error": reason, "failed_at": time.Now()},
+            "$inc": bson.M{"attempts": 1},
+        },
+    )
+    return err
+}
+
+// CountPending returns the backlog of the outbox
+func (repo *MongoOutboxRepository) CountPending(ctx context.Context) (int64, error) {
+    return repo.collection.CountDocuments(ctx, bson.M{"status": OutboxStatusPending})
+}
@@ -0,0 +1,149 @@
+package repositories
+
+import (
+    "context"
+    "log"
+    "testing"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMongoOutboxRepository_ClaimPending(t *testing.T) {
+    client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(connStr))
+    if err != nil {
+        log.Fatal("Database connection failed:", err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    repo, err := NewMongoOutboxRepository(context.Background(), client.Database("vehicles"))
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // start from an empty outbox, so the claimed message is the one added below
+    if _, err := repo.collection.DeleteMany(context.Background(), bson.M{}); err != nil {
+        t.Fatal(err)
+    }
+
+    messageID := primitive.NewObjectID().Hex()
+
+    for i := 0; i < 2; i++ {
+        if err := repo.AddMessage(context.Background(), messageID, []byte(`{"vehicle_id":"1"}`)); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    pending, err := repo.CountPending(context.Background())
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if pending != 1 {
+        t.Fatal("Same message ID should only be queued once")
+    }
+
+    message, err := repo.ClaimPending(context.Background(), time.Minute)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if message == nil || message.MessageID != messageID {
+        t.Fatal("Pending message should be claimed")
+    }
+
+    claimedAgain, err := repo.ClaimPending(context.Background(), time.Minute)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if claimedAgain != nil {
+        t.Fatal("Leased message should not be claimed again")
+    }
+
+    if err := repo.MarkFailed(context.Background(), message.ID, "broker is down", time.Now()); err != nil {
+        t.Fatal(err)
+    }
+
+    message, err = repo.ClaimPending(context.Background(), time.Minute)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if message == nil || message.Attempts != 1 || message.LastError != "broker is down" {
+        t.Fatal("Failed message should be claimed again with its attempt recorded")
+    }
+
+    // a newer message waits until the failed one, which is backing off, was published
+    if err := repo.AddMessage(context.Background(), primitive.NewObjectID().Hex(), []byte(`{}`)); err != nil {
+        t.Fatal(err)
+    }
+
+    err = repo.MarkFailed(context.Background(), message.ID, "broker is down", time.Now().Add(time.Hour))
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    newer, err := repo.ClaimPending(context.Background(), time.Minute)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if newer != nil {
+        t.Fatal("Newer message should not overtake the failed one")
+    }
+
+    if err := repo.MarkSent(context.Background(), message.ID); err != nil {
+        t.Fatal(err)
+    }
+
+    pending, err = repo.CountPending(context.Background())
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if pending != 1 {
+        t.Fatal("Only the newer message should be pending")
+    }
+
+    newer, err = repo.ClaimPending(context.Background(), time.Minute)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if newer == nil {
+        t.Fatal("Newer message should be claimed once the older one was sent")
+    }
+
+    if err := repo.MarkUndeliverable(context.Background(), newer.ID, "unroutable"); err != nil {
+        t.Fatal(err)
+    }
+
+    pending, err = repo.CountPending(context.Background())
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if pending != 0 {
+        t.Fatal("Undeliverable message should not be pending")
+    }
+}
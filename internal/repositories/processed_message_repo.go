@@ -0,0 +1,144 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    processedMessagesCollection = "processed_messages"
+    // indexOptionsConflictCode is returned by MongoDB when an index exists with different options
+    indexOptionsConflictCode = 85
+    // claimLease is how long a claim is held for a consumer which didn't mark the message as processed,
+    // after it the consumer is assumed to be gone and the message can be claimed again
+    claimLease = time.Minute
+)
+
+var (
+    ErrMessageInProgress = NewError(
+        KindUnavailable,
+        "message_in_progress",
+        "tracking message is being processed by another consumer",
+    )
+)
+
+type ProcessedMessageRepository interface {
+    Claim(ctx context.Context, messageID string) (bool, error)
+    Release(ctx context.Context, messageID string) error
+    MarkProcessed(ctx context.Context, messageID string) error
+}
+
+// MongoProcessedMessageRepository remembers the IDs of the tracking messages which were claimed or applied,
+// the entries expire through a TTL index, so the collection only covers the redelivery window
+type MongoProcessedMessageRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoProcessedMessageRepository(
+    ctx context.Context,
+    db *mongo.Database,
+    ttl time.Duration,
+) (*MongoProcessedMessageRepository, error) {
+    collection := db.Collection(processedMessagesCollection)
+
+    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+    defer cancel()
+
+    expireAfterSeconds := int32(ttl.Seconds())
+
+    _, err := collection.Indexes().CreateOne(
+        ctx, mongo.IndexModel{
+            Keys:    bson.M{"processed_at": 1},
+            Options: options.Index().SetExpireAfterSeconds(expireAfterSeconds),
+        },
+    )
+    if err != nil {
+        var cmdErr mongo.CommandError
+        if !errors.As(err, &cmdErr) || cmdErr.Code != indexOptionsConflictCode {
+            return nil, err
+        }
+        // the TTL was changed in the config, update the existing index instead of failing
+        err = db.RunCommand(
+            ctx, bson.D{
+                {Key: "collMod", Value: processedMessagesCollection},
+                {
+                    Key: "index", Value: bson.D{
+                        {Key: "keyPattern", Value: bson.D{{Key: "processed_at", Value: 1}}},
+                        {Key: "expireAfterSeconds", Value: expireAfterSeconds},
+                    },
+                },
+            },
+        ).Err()
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return &MongoProcessedMessageRepository{
+        collection: collection,
+    }, nil
+}
+
+// Claim reserves the message for the caller before it is applied, so a redelivered copy can't be applied concurrently,
+// it returns false if the message was already processed and ErrMessageInProgress while another consumer holds it.
+// The claim is a single upsert on the message ID, two consumers racing for it fail with a duplicate key error
+func (repo *MongoProcessedMessageRepository) Claim(ctx context.Context, messageID string) (bool, error) {
+    now := time.Now()
+    _, err := repo.collection.UpdateOne(
+        ctx,
+        // only a claim which wasn't marked as processed and whose lease ran out can be taken over
+        bson.M{"_id": messageID, "processed": false, "processed_at": bson.M{"$lt": now.Add(-claimLease)}},
+        bson.M{"$set": bson.M{"processed": false, "processed_at": now}},
+        options.Update().SetUpsert(true),
+    )
+    if err == nil {
+        return true, nil
+    }
+    if !mongo.IsDuplicateKeyError(err) {
+        return false, err
+    }
+    processed, err := repo.IsProcessed(ctx, messageID)
+    if err != nil {
+        return false, err
+    }
+    if !processed {
+        return false, ErrMessageInProgress
+    }
+    return false, nil
+}
+
+// Release gives up the claim of a message which wasn't applied, so it can be claimed by its retry or redelivery
+func (repo *MongoProcessedMessageRepository) Release(ctx context.Context, messageID string) error {
+    _, err := repo.collection.DeleteOne(ctx, bson.M{"_id": messageID, "processed": false})
+    return err
+}
+
+func (repo *MongoProcessedMessageRepository) IsProcessed(ctx context.Context, messageID string) (bool, error) {
+    // the entries written before messages were claimed don't have the processed flag
+    count, err := repo.collection.CountDocuments(
+        ctx,
+        bson.M{"_id": messageID, "processed": bson.M{"$ne": false}},
+        options.Count().SetLimit(1),
+    )
+    if err != nil {
+        return false, err
+    }
+    return count > 0, nil
+}
+
+// MarkProcessed records the message ID as applied (whether it was claimed or not),
+// marking the same message twice is not an error
+func (repo *MongoProcessedMessageRepository) MarkProcessed(ctx context.Context, messageID string) error {
+    _, err := repo.collection.UpdateOne(
+        ctx,
+        bson.M{"_id": messageID},
+        bson.M{"$set": bson.M{"processed": true, "processed_at": time.Now()}},
+        options.Update().SetUpsert(true),
+    )
+    return err
+}
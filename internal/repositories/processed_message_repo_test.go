@@ -0,0 +1,115 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "log"
+    "testing"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMongoProcessedMessageRepository_MarkProcessed(t *testing.T) {
+    client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(connStr))
+    if err != nil {
+        log.Fatal("Database connection failed:", err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    repo, err := NewMongoProcessedMessageRepository(context.Background(), client.Database("vehicles"), time.Hour)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    messageID := primitive.NewObjectID().Hex()
+
+    processed, err := repo.IsProcessed(context.Background(), messageID)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if processed {
+        t.Fatal("Message should not be processed yet")
+    }
+
+    for i := 0; i < 2; i++ {
+        if err := repo.MarkProcessed(context.Background(), messageID); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    processed, err = repo.IsProcessed(context.Background(), messageID)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !processed {
+        t.Fatal("Message should be processed")
+    }
+}
+
+func TestMongoProcessedMessageRepository_Claim(t *testing.T) {
+    client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(connStr))
+    if err != nil {
+        log.Fatal("Database connection failed:", err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    repo, err := NewMongoProcessedMessageRepository(context.Background(), client.Database("vehicles"), time.Hour)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    messageID := primitive.NewObjectID().Hex()
+
+    claimed, err := repo.Claim(context.Background(), messageID)
+
+    if err != nil || !claimed {
+        t.Fatal("Message should be claimed")
+    }
+
+    _, err = repo.Claim(context.Background(), messageID)
+
+    if !errors.Is(err, ErrMessageInProgress) {
+        t.Fatal("Error should be ErrMessageInProgress")
+    }
+
+    if err := repo.Release(context.Background(), messageID); err != nil {
+        t.Fatal(err)
+    }
+
+    claimed, err = repo.Claim(context.Background(), messageID)
+
+    if err != nil || !claimed {
+        t.Fatal("Released message should be claimed again")
+    }
+
+    if err := repo.MarkProcessed(context.Background(), messageID); err != nil {
+        t.Fatal(err)
+    }
+
+    claimed, err = repo.Claim(context.Background(), messageID)
+
+    if err != nil || claimed {
+        t.Fatal("Processed message should not be claimed")
+    }
+}
@@ -0,0 +1,222 @@
+package repositories
+
+import (
+    "context"
+    "fmt"
+    "sync"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// confirmChannel is a channel in confirm mode together with the publishes waiting for their confirmation
+type confirmChannel struct {
+    channel *amqp.Channel
+    // flush carries the flush requests of the returns listener, see RabbitMqTrackingRepository.waitReturns
+    flush chan chan struct{}
+
+    mu sync.Mutex
+    // pending holds the publishes which are waiting for their confirmation, by message ID
+    pending map[string]chan amqp.Return
+}
+
+// listenReturns hands the messages returned by the broker (mandatory, but not routable) to their publisher,
+// it also answers the flush requests of the channel
+func (c *confirmChannel) listenReturns(returns <-chan amqp.Return) {
+    for {
+        select {
+        case ret, ok := <-returns:
+            if !ok {
+                return
+            }
+            c.mu.Lock()
+            if returned, ok := c.pending[ret.MessageId]; ok {
+                select {
+                case returned <- ret:
+                default:
+                }
+            }
+            c.mu.Unlock()
+        case done := <-c.flush:
+            close(done)
+        }
+    }
+}
+
+// waitReturns makes sure the returns received before the confirmation are handed over,
+// the broker sends basic.return before basic.ack and the client reads them in order,
+// so once the listener answers a flush requested after the ack, a return of the message was already delivered
+func (c *confirmChannel) waitReturns(ctx context.Context) error {
+    done := make(chan struct{})
+    select {
+    case c.flush <- done:
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// RabbitChannelPool hands out publishing channels, a channel is used by a single publisher at a time.
+// The pool has its own connection, so publishing never competes with the consumer's channel,
+// and the broker throttling the publishers doesn't block the consumer's acks
+type RabbitChannelPool struct {
+    connStr string
+
+    mu   sync.Mutex
+    conn *amqp.Connection
+
+    // idle holds the channels which were returned to the pool
+    idle chan *confirmChannel
+    // open limits the number of channels, it holds a token for every channel which was opened and not discarded
+    open chan struct{}
+}
+
+// NewRabbitChannelPool creates a pool of at most size channels, the channels are opened when they are needed
+func NewRabbitChannelPool(connStr string, size int) *RabbitChannelPool {
+    if size < 1 {
+        size = 1
+    }
+    return &RabbitChannelPool{
+        connStr: connStr,
+        idle:    make(chan *confirmChannel, size),
+        open:    make(chan struct{}, size),
+    }
+}
+
+// get returns an idle channel, or opens a new one if the pool is not full,
+// otherwise it waits until a channel is returned or the context is done
+func (p *RabbitChannelPool) get(ctx context.Context) (*confirmChannel, error) {
+    for {
+        // prefer the channels which are already open
+        select {
+        case current := <-p.idle:
+            if current.channel.IsClosed() {
+                p.discard()
+                continue
+            }
+            return current, nil
+        default:
+        }
+
+        select {
+        case current := <-p.idle:
+            if current.channel.IsClosed() {
+                p.discard()
+                continue
+            }
+            return current, nil
+        case p.open <- struct{}{}:
+            current, err := p.newChannel()
+            if err != nil {
+                p.discard()
+                return nil, err
+            }
+            return current, nil
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        }
+    }
+}
+
+// put returns the channel to the pool, a closed channel is dropped and replaced on the next get
+func (p *RabbitChannelPool) put(current *confirmChannel) {
+    if current.channel.IsClosed() {
+        p.discard()
+        return
+    }
+    p.idle <- current
+}
+
+func (p *RabbitChannelPool) discard() {
+    <-p.open
+}
+
+// newChannel opens a channel in confirm mode, reconnecting first if the connection is closed
+func (p *RabbitChannelPool) newChannel() (*confirmChannel, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if p.conn == nil || p.conn.IsClosed() {
+        conn, err := amqp.Dial(p.connStr)
+        if err != nil {
+            return nil, err
+        }
+        p.conn = conn
+    }
+
+    channel, err := p.conn.Channel()
+    if err != nil {
+        return nil, err
+    }
+    if err := channel.Confirm(false); err != nil {
+        _ = channel.Close()
+        return nil, err
+    }
+
+    current := &confirmChannel{
+        channel: channel,
+        flush:   make(chan chan struct{}),
+        pending: make(map[string]chan amqp.Return),
+    }
+    go current.listenReturns(channel.NotifyReturn(make(chan amqp.Return)))
+    return current, nil
+}
+
+// Publish publishes the message on one of the pool's channels and only returns after the broker confirmed it,
+// it fails when the message was nacked, could not be routed (it is published as mandatory)
+// or wasn't confirmed before the context is done
+func (p *RabbitChannelPool) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+    current, err := p.get(ctx)
+    if err != nil {
+        return fmt.Errorf("%w: %w: %w", ErrPublishUnconfirmed, ErrChannelUnavailable, err)
+    }
+    defer p.put(current)
+
+    returned := make(chan amqp.Return, 1)
+    current.mu.Lock()
+    current.pending[msg.MessageId] = returned
+    current.mu.Unlock()
+    defer func() {
+        current.mu.Lock()
+        delete(current.pending, msg.MessageId)
+        current.mu.Unlock()
+    }()
+
+    // mandatory, the broker returns the message if there is no queue to route it to
+    confirmation, err := current.channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+    if err != nil {
+        return fmt.Errorf("%w: %w", ErrPublishUnconfirmed, err)
+    }
+
+    acked, err := confirmation.WaitContext(ctx)
+    if err != nil {
+        return fmt.Errorf("%w: %w", ErrPublishUnconfirmed, err)
+    }
+    if !acked {
+        return ErrPublishNacked
+    }
+
+    if err := current.waitReturns(ctx); err != nil {
+        return fmt.Errorf("%w: %w", ErrPublishUnconfirmed, err)
+    }
+    select {
+    case ret := <-returned:
+        return fmt.Errorf("%w: %s", ErrPublishReturned, ret.ReplyText)
+    default:
+        return nil
+    }
+}
+
+// Close closes the pool's connection together with its channels
+func (p *RabbitChannelPool) Close() error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.conn == nil || p.conn.IsClosed() {
+        return nil
+    }
+    return p.conn.Close()
+}
@@ -0,0 +1,155 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    trackingHistoryCollection = "tracking_history"
+    // namespaceExistsCode is returned by MongoDB when the collection was already created
+    namespaceExistsCode = 48
+)
+
+type TrackingHistoryFilter struct {
+    VehicleID string
+    From      time.Time
+    To        time.Time
+    Page      int
+    PageSize  int
+    vehicleID primitive.ObjectID
+}
+
+func (f *TrackingHistoryFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    if f.PageSize == 0 {
+        f.PageSize = 50
+    }
+    if f.PageSize > 500 {
+        f.PageSize = 500
+    }
+    objectID, err := vehicleObjectID(f.VehicleID)
+    if err != nil {
+        return err
+    }
+    f.vehicleID = objectID
+    return nil
+}
+
+type TrackingHistoryRepository interface {
+    SaveTrackingData(ctx context.Context, data *models.TrackingData) error
+    FindTrackingHistory(ctx context.Context, filter *TrackingHistoryFilter) ([]*models.TrackingData, error)
+}
+
+// MongoTrackingHistoryRepository stores every tracking message in a time-series collection,
+// the vehicle ID is the meta field so the data points of a vehicle are bucketed together
+type MongoTrackingHistoryRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoTrackingHistoryRepository(
+    ctx context.Context,
+    db *mongo.Database,
+) (*MongoTrackingHistoryRepository, error) {
+    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+    defer cancel()
+
+    err := db.CreateCollection(
+        ctx,
+        trackingHistoryCollection,
+        options.CreateCollection().SetTimeSeriesOptions(
+            options.TimeSeries().
+                SetTimeField("created_at").
+                SetMetaField("vehicle_id").
+                SetGranularity("seconds"),
+        ),
+    )
+    if err != nil {
+        var cmdErr mongo.CommandError
+        if !errors.As(err, &cmdErr) || cmdErr.Code != namespaceExistsCode {
+            return nil, err
+        }
+    }
+
+    collection := db.Collection(trackingHistoryCollection)
+
+    _, err = collection.Indexes().CreateOne(
+        ctx, mongo.IndexModel{
+            Keys: bson.D{{Key: "vehicle_id", Value: 1}, {Key: "created_at", Value: 1}},
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    return &MongoTrackingHistoryRepository{
+        collection: collection,
+    }, nil
+}
+
+func (repo *MongoTrackingHistoryRepository) SaveTrackingData(ctx context.Context, data *models.TrackingData) error {
+    if err := data.Build(); err != nil {
+        return err
+    }
+    _, err := repo.collection.InsertOne(ctx, data)
+    return err
+}
+
+// FindTrackingHistory returns the tracking data of a vehicle in chronological order
+func (repo *MongoTrackingHistoryRepository) FindTrackingHistory(
+    ctx context.Context,
+    filter *TrackingHistoryFilter,
+) ([]*models.TrackingData, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+
+    bsonMFilter := bson.M{"vehicle_id": filter.vehicleID}
+    createdAt := bson.M{}
+    if !filter.From.IsZero() {
+        createdAt["$gte"] = filter.From
+    }
+    if !filter.To.IsZero() {
+        createdAt["$lte"] = filter.To
+    }
+    if len(createdAt) > 0 {
+        bsonMFilter["created_at"] = createdAt
+    }
+
+    findOptions := options.Find().
+        SetSort(bson.D{{Key: "created_at", Value: 1}}).
+        SetSkip(int64((filter.Page - 1) * filter.PageSize)).
+        SetLimit(int64(filter.PageSize))
+
+    cursor, err := repo.collection.Find(ctx, bsonMFilter, findOptions)
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            slog.ErrorContext(ctx, "Failed to close cursor", slog.Any("error", err))
+        }
+    }(cursor, ctx)
+
+    history := make([]*models.TrackingData, 0, filter.PageSize)
+    for cursor.Next(ctx) {
+        var data models.TrackingData
+        if err := cursor.Decode(&data); err != nil {
+            return nil, err
+        }
+        history = append(history, &data)
+    }
+
+    return history, cursor.Err()
+}
@@ -0,0 +1,98 @@
+package repositories
+
+import (
+    "context"
+    "log"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func getTrackingHistoryRepo() (*mongo.Client, *MongoTrackingHistoryRepository, error) {
+    client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(connStr))
+    if err != nil {
+        log.Fatal("Database connection failed:", err)
+    }
+
+    repo, err := NewMongoTrackingHistoryRepository(context.Background(), client.Database("vehicles"))
+
+    if err != nil {
+        return nil, nil, err
+    }
+
+    return client, repo, nil
+}
+
+func TestMongoTrackingHistoryRepository_FindTrackingHistory(t *testing.T) {
+    client, repo, err := getTrackingHistoryRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    vehicleID := primitive.NewObjectID()
+    start := time.Now().Add(-time.Hour)
+
+    for i := 0; i < 5; i++ {
+        data := models.NewTrackingData().
+            SetLocation("16.8409,96.1735").
+            SetMileage(float64(100 + i)).
+            SetStatus(models.VehicleStatusActive).
+            SetFuelCondition(models.FuelConditionFull)
+        data.VehicleID = vehicleID
+        data.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+
+        if err := repo.SaveTrackingData(context.Background(), data); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    history, err := repo.FindTrackingHistory(
+        context.Background(), &TrackingHistoryFilter{
+            VehicleID: vehicleID.Hex(),
+            From:      start.Add(time.Minute),
+            To:        start.Add(3 * time.Minute),
+        },
+    )
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(history) != 3 {
+        t.Fatal("Should return 3 data points")
+    }
+
+    for i := 0; i < len(history)-1; i++ {
+        if history[i].CreatedAt.After(history[i+1].CreatedAt) {
+            t.Fatal("History should be in chronological order")
+        }
+    }
+
+    history, err = repo.FindTrackingHistory(
+        context.Background(), &TrackingHistoryFilter{
+            VehicleID: vehicleID.Hex(),
+            Page:      2,
+            PageSize:  2,
+        },
+    )
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(history) != 2 {
+        t.Fatal("Should return 2 data points")
+    }
+}
@@ -2,13 +2,33 @@ package repositories
 
 import (
     "context"
+    "errors"
+    "time"
 
     amqp "github.com/rabbitmq/amqp091-go"
     "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/logging"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/metrics"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/tracing"
+    "go.opentelemetry.io/otel/codes"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+    "go.opentelemetry.io/otel/trace"
+)
+
+const (
+    // defaultConfirmTimeout is used when the request context has no earlier deadline
+    defaultConfirmTimeout = 5 * time.Second
+)
+
+var (
+    ErrPublishNacked      = errors.New("tracking data was rejected by the broker")
+    ErrPublishReturned    = errors.New("tracking data could not be routed to the tracking queue")
+    ErrPublishUnconfirmed = errors.New("tracking data was not confirmed by the broker")
+    ErrChannelUnavailable = errors.New("rabbitmq channel is not available")
 )
 
 type TrackingRepository interface {
-    PublishTrackingData(ctx context.Context, message []byte) error
+    PublishTrackingData(ctx context.Context, messageID string, message []byte) error
     // Close() error
 }
 
@@ -18,38 +38,96 @@ type TrackingRepository interface {
 type RabbitMqTrackingRepository struct {
     queue string
     // conn  *RabbitConnection
-    channel *amqp.Channel
+    pool           *RabbitChannelPool
+    confirmTimeout time.Duration
 }
 
 // NewRabbitMqTrackingRepository creates a new RabbitMqTrackingRepository
 // we don't need to use RabbitConnection here, because we need to consume the message,
-// since connection is still open, garbage collector will not close the connection
-func NewRabbitMqTrackingRepository(channel *amqp.Channel, queue string) *RabbitMqTrackingRepository {
+// since connection is still open, garbage collector will not close the connection.
+// Every publish checks out its own channel from the pool, so concurrent publishes never share a channel
+func NewRabbitMqTrackingRepository(pool *RabbitChannelPool, queue string) *RabbitMqTrackingRepository {
     return &RabbitMqTrackingRepository{
         // conn:  NewRabbitConnection(connStr),
-        queue:   queue,
-        channel: channel,
+        pool:           pool,
+        queue:          queue,
+        confirmTimeout: defaultConfirmTimeout,
     }
 }
 
-// PublishTrackingData publishes the tracking data to RabbitMQ queue
-func (r *RabbitMqTrackingRepository) PublishTrackingData(ctx context.Context, message []byte) error {
+// SetConfirmTimeout sets how long a publish waits for the broker's confirmation
+func (r *RabbitMqTrackingRepository) SetConfirmTimeout(timeout time.Duration) *RabbitMqTrackingRepository {
+    r.confirmTimeout = timeout
+    return r
+}
+
+// PublishTrackingData publishes the tracking data to RabbitMQ queue,
+// the message ID lets the consumer skip the message if it is delivered more than once.
+// It only returns after the broker confirmed the message, it fails when the message was nacked,
+// could not be routed to the queue or wasn't confirmed before the context is done
+func (r *RabbitMqTrackingRepository) PublishTrackingData(ctx context.Context, messageID string, message []byte) error {
+    ctx, span := tracer.Start(
+        ctx,
+        r.queue+" publish",
+        trace.WithSpanKind(trace.SpanKindProducer),
+        trace.WithAttributes(
+            semconv.MessagingSystemRabbitmq,
+            semconv.MessagingOperationTypePublish,
+            semconv.MessagingDestinationName(r.queue),
+            semconv.MessagingMessageID(messageID),
+        ),
+    )
+    defer span.End()
+
+    err := r.publish(ctx, messageID, message)
+    if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    metrics.PublishedMessages.WithLabelValues(publishResult(err)).Inc()
+    return err
+}
+
+// publishResult is the label of the publish's outcome in the publisher metrics
+func publishResult(err error) string {
+    switch {
+    case err == nil:
+        return "success"
+    case errors.Is(err, ErrPublishNacked):
+        return "nacked"
+    case errors.Is(err, ErrPublishReturned):
+        return "returned"
+    default:
+        return "unconfirmed"
+    }
+}
+
+func (r *RabbitMqTrackingRepository) publish(ctx context.Context, messageID string, message []byte) error {
     // channel, err := r.conn.Channel()
     // if err != nil {
     //     return err
     // }
-    err := r.channel.PublishWithContext(
+    ctx, cancel := context.WithTimeout(ctx, r.confirmTimeout)
+    defer cancel()
+
+    headers := amqp.Table{}
+    tracing.InjectAMQP(ctx, headers)
+
+    return r.pool.Publish(
         ctx,
         "",
         r.queue,
-        false,
-        false,
         amqp.Publishing{
-            ContentType: common.ApplicationJSON,
-            Body:        message,
+            ContentType:  common.ApplicationJSON,
+            DeliveryMode: amqp.Persistent,
+            MessageId:    messageID,
+            // the consumer logs the ID of the request which queued the message with its own records
+            CorrelationId: logging.RequestID(ctx),
+            // the trace context of the publish span, so the consumer continues the trace
+            Headers: headers,
+            Body:    message,
         },
     )
-    return err
 }
 
 // 
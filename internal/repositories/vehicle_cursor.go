@@ -0,0 +1,154 @@
+package repositories
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "fmt"
+    "reflect"
+    "strings"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrInvalidCursor = NewError(KindInvalidArgument, "invalid_cursor", "invalid cursor")
+
+// vehicleCursor is the position of a vehicle in the sorted list, the values of the sort keys and the ID
+// are enough to find the vehicles around it with an indexed query instead of skipping the previous pages.
+// It is handed to the client as an opaque token, signed so a client can't put its own values into the query
+type vehicleCursor struct {
+    // Sort is the sort the cursor was created for, e.g. -mileage,created_at
+    Sort   string             `bson:"s"`
+    Values bson.A             `bson:"v"`
+    ID     primitive.ObjectID `bson:"i"`
+    // Before lists the vehicles before the position instead of the ones after it
+    Before bool `bson:"b,omitempty"`
+}
+
+// sortValueTypes are the types of the sort keys' values, a cursor value of another type (e.g. a document holding
+// a query operator) is rejected, it would be inserted into the query as it is
+var sortValueTypes = map[string]reflect.Type{
+    "created_at":     reflect.TypeOf(primitive.DateTime(0)),
+    "vehicle_name":   reflect.TypeOf(""),
+    "license_number": reflect.TypeOf(""),
+    "vehicle_status": reflect.TypeOf(""),
+    "mileage":        reflect.TypeOf(0.0),
+}
+
+// sortSignature is the sort of the keys in the form of the sort query parameter
+func sortSignature(keys []SortKey) string {
+    fields := make([]string, 0, len(keys))
+    for _, key := range keys {
+        if key.Descending {
+            fields = append(fields, "-"+key.Field)
+            continue
+        }
+        fields = append(fields, key.Field)
+    }
+    return strings.Join(fields, ",")
+}
+
+// newVehicleCursor creates the cursor of the vehicle document in the sorted list
+func newVehicleCursor(document bson.Raw, keys []SortKey, before bool) (*vehicleCursor, error) {
+    cursor := &vehicleCursor{Sort: sortSignature(keys), Before: before}
+    for _, key := range keys {
+        value, err := document.LookupErr(key.Field)
+        if err != nil {
+            return nil, err
+        }
+        cursor.Values = append(cursor.Values, value)
+    }
+    id, ok := document.Lookup("_id").ObjectIDOK()
+    if !ok {
+        return nil, fmt.Errorf("vehicle document has no ObjectID")
+    }
+    cursor.ID = id
+    return cursor, nil
+}
+
+// cursorMAC is the signature of the encoded cursor
+func cursorMAC(payload string, key []byte) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(payload))
+    return mac.Sum(nil)
+}
+
+// Encode returns the token of the cursor, the encoded cursor and its signature separated by a dot
+func (c *vehicleCursor) Encode(key []byte) (string, error) {
+    buf, err := bson.Marshal(c)
+    if err != nil {
+        return "", err
+    }
+    payload := base64.RawURLEncoding.EncodeToString(buf)
+    return payload + "." + base64.RawURLEncoding.EncodeToString(cursorMAC(payload, key)), nil
+}
+
+// encodeVehicleCursor returns the token of the vehicle document's cursor signed with the key
+func encodeVehicleCursor(document bson.Raw, keys []SortKey, before bool, key []byte) (string, error) {
+    cursor, err := newVehicleCursor(document, keys, before)
+    if err != nil {
+        return "", err
+    }
+    return cursor.Encode(key)
+}
+
+// decodeVehicleCursor decodes the token of a cursor created for the same sort and signed with the key
+func decodeVehicleCursor(token string, keys []SortKey, key []byte) (*vehicleCursor, error) {
+    invalid := func(message string) error {
+        return NewValidationError(
+            fmt.Errorf("%w: %s", ErrInvalidCursor, message),
+            FieldError{Field: "cursor", Rule: "cursor", Message: message},
+        )
+    }
+
+    payload, signature, ok := strings.Cut(token, ".")
+    if !ok {
+        return nil, invalid("cursor is malformed")
+    }
+    mac, err := base64.RawURLEncoding.DecodeString(signature)
+    if err != nil || !hmac.Equal(mac, cursorMAC(payload, key)) {
+        return nil, invalid("cursor signature is invalid")
+    }
+    buf, err := base64.RawURLEncoding.DecodeString(payload)
+    if err != nil {
+        return nil, invalid("cursor is malformed")
+    }
+    var cursor vehicleCursor
+    if err := bson.Unmarshal(buf, &cursor); err != nil {
+        return nil, invalid("cursor is malformed")
+    }
+    if cursor.Sort != sortSignature(keys) || len(cursor.Values) != len(keys) {
+        return nil, invalid("cursor was created for another sort")
+    }
+    for i, key := range keys {
+        if reflect.TypeOf(cursor.Values[i]) != sortValueTypes[key.Field] {
+            return nil, invalid(fmt.Sprintf("cursor value of %s is invalid", key.Field))
+        }
+    }
+    return &cursor, nil
+}
+
+// query is the condition of the vehicles after (or before) the cursor in the sort of the keys,
+// e.g. for -mileage,created_at after the cursor is
+// mileage < m or (mileage = m and created_at > c) or (mileage = m and created_at = c and _id > id)
+func (c *vehicleCursor) query(keys []SortKey) bson.A {
+    // _id is the tie-breaker of every sort, in the order of the last key
+    keys = append(keys[:len(keys):len(keys)], SortKey{Field: "_id", Descending: keys[len(keys)-1].Descending})
+    values := append(c.Values[:len(c.Values):len(c.Values)], c.ID)
+
+    conditions := make(bson.A, 0, len(keys))
+    for i, key := range keys {
+        condition := bson.M{}
+        for j := 0; j < i; j++ {
+            condition[keys[j].Field] = values[j]
+        }
+        operator := "$gt"
+        if key.Descending != c.Before {
+            operator = "$lt"
+        }
+        condition[key.Field] = bson.M{operator: values[i]}
+        conditions = append(conditions, condition)
+    }
+    return conditions
+}
@@ -0,0 +1,104 @@
+package repositories
+
+import (
+    "errors"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var cursorKey = []byte("cursor key")
+
+func TestVehicleCursor(t *testing.T) {
+    keys := []SortKey{{Field: "mileage", Descending: true}, {Field: "created_at"}}
+    id := primitive.NewObjectID()
+    createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+    document, err := bson.Marshal(bson.M{"_id": id, "mileage": 1500.5, "created_at": createdAt})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    token, err := encodeVehicleCursor(document, keys, false, cursorKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    cursor, err := decodeVehicleCursor(token, keys, cursorKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    mileage, dateTime := 1500.5, primitive.NewDateTimeFromTime(createdAt)
+    want := bson.A{
+        bson.M{"mileage": bson.M{"$lt": mileage}},
+        bson.M{"mileage": mileage, "created_at": bson.M{"$gt": dateTime}},
+        bson.M{"mileage": mileage, "created_at": dateTime, "_id": bson.M{"$gt": id}},
+    }
+    if query := cursor.query(keys); !reflect.DeepEqual(query, want) {
+        t.Fatalf("Query should be %v, got %v", want, query)
+    }
+
+    cursor.Before = true
+    want = bson.A{
+        bson.M{"mileage": bson.M{"$gt": mileage}},
+        bson.M{"mileage": mileage, "created_at": bson.M{"$lt": dateTime}},
+        bson.M{"mileage": mileage, "created_at": dateTime, "_id": bson.M{"$lt": id}},
+    }
+    if query := cursor.query(keys); !reflect.DeepEqual(query, want) {
+        t.Fatalf("Query before the cursor should be %v, got %v", want, query)
+    }
+}
+
+func TestDecodeVehicleCursor_Invalid(t *testing.T) {
+    keys := []SortKey{{Field: "created_at"}}
+
+    document, err := bson.Marshal(bson.M{"_id": primitive.NewObjectID(), "created_at": time.Now()})
+    if err != nil {
+        t.Fatal(err)
+    }
+    token, err := encodeVehicleCursor(document, keys, false, cursorKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    payload, _, _ := strings.Cut(token, ".")
+    otherKey, err := encodeVehicleCursor(document, keys, false, []byte("other key"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    // a valid signature doesn't make a value of another type acceptable
+    operator, err := (&vehicleCursor{Sort: "created_at", Values: bson.A{bson.M{"$ne": nil}}}).Encode(cursorKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    mileage, err := (&vehicleCursor{Sort: "mileage", Values: bson.A{"1500"}}).Encode(cursorKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        token string
+        keys  []SortKey
+    }{
+        {"not a cursor", keys},
+        {"bm90IGJzb24", keys},
+        {payload, keys},
+        {payload + ".c2lnbmF0dXJl", keys},
+        {otherKey, keys},
+        {operator, keys},
+        {mileage, []SortKey{{Field: "mileage"}}},
+        {token, []SortKey{{Field: "created_at", Descending: true}}},
+        {token, []SortKey{{Field: "mileage"}}},
+    }
+
+    for _, test := range tests {
+        if _, err := decodeVehicleCursor(test.token, test.keys, cursorKey); !errors.Is(err, ErrInvalidCursor) {
+            t.Fatalf("%s should be an invalid cursor, got %v", test.token, err)
+        }
+    }
+}
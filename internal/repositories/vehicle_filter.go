@@ -0,0 +1,370 @@
+package repositories
+
+import (
+    "fmt"
+    "maps"
+    "regexp"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TextMatch is how a text filter is matched against the field, the not_ variants exclude the matching vehicles
+type TextMatch string
+
+const (
+    TextMatchPrefix      TextMatch = "prefix"
+    TextMatchExact       TextMatch = "exact"
+    TextMatchContains    TextMatch = "contains"
+    TextMatchNotPrefix   TextMatch = "not_prefix"
+    TextMatchNotExact    TextMatch = "not_exact"
+    TextMatchNotContains TextMatch = "not_contains"
+)
+
+// Valid returns an error if the match is unknown, the empty match is a prefix match
+func (m TextMatch) Valid() error {
+    switch m {
+    case "", TextMatchPrefix, TextMatchExact, TextMatchContains,
+        TextMatchNotPrefix, TextMatchNotExact, TextMatchNotContains:
+        return nil
+    }
+    return fmt.Errorf("%w: unknown match %s", ErrInvalidQuery, m)
+}
+
+// textQuery is the condition of a text filter, prefix and contains are case-insensitive, exact is not
+// so it can use the indexes. The value is escaped, so its regex metacharacters (e.g. .*) are matched literally
+func textQuery(value string, match TextMatch) any {
+    pattern := regexp.QuoteMeta(value)
+    switch match {
+    case TextMatchExact:
+        return value
+    case TextMatchNotExact:
+        return bson.M{"$ne": value}
+    case TextMatchContains:
+        return bson.M{"$regex": pattern, "$options": "i"}
+    case TextMatchNotContains:
+        return bson.M{"$not": primitive.Regex{Pattern: pattern, Options: "i"}}
+    case TextMatchNotPrefix:
+        return bson.M{"$not": primitive.Regex{Pattern: "^" + pattern, Options: "i"}}
+    default:
+        return bson.M{"$regex": "^" + pattern, "$options": "i"}
+    }
+}
+
+// maxPlateGroups is how many words a license number is written in at most, e.g. "4B 12 34"
+const maxPlateGroups = 3
+
+// SortIndexes are the sorts the vehicles can be listed in, NewMongoVehicleRepository creates an index
+// ({archived, fields..., _id}) for each. A sort is supported if its fields are one of them, in the same order,
+// and they are all ascending or all descending, so the index is read forwards or backwards instead of sorting
+var SortIndexes = [][]string{
+    {"created_at"},
+    {"vehicle_name"},
+    {"license_number"},
+    {"mileage"},
+    {"vehicle_status", "created_at"},
+}
+
+// supportedSort reports whether a sort is served by one of the SortIndexes
+func supportedSort(keys []SortKey) bool {
+    fields := make([]string, 0, len(keys))
+    for _, key := range keys {
+        if key.Descending != keys[0].Descending {
+            return false
+        }
+        fields = append(fields, key.Field)
+    }
+    return slices.ContainsFunc(
+        SortIndexes, func(index []string) bool {
+            return slices.Equal(index, fields)
+        },
+    )
+}
+
+// supportedSorts lists the SortIndexes the way they are written in the sort parameter
+func supportedSorts() string {
+    sorts := make([]string, 0, len(SortIndexes))
+    for _, index := range SortIndexes {
+        sorts = append(sorts, strings.Join(index, ","))
+    }
+    return strings.Join(sorts, "; ")
+}
+
+// SortKey is one of the fields of a multi-key sort
+type SortKey struct {
+    Field      string `json:"field"`
+    Descending bool   `json:"descending"`
+}
+
+type VehicleFilter struct {
+    Page     int `json:"page"`
+    PageSize int `json:"limit"`
+    // Cursor is the next_cursor or prev_cursor of a page, it is used instead of Page
+    Cursor string `json:"cursor"`
+    // Sort is the multi-key sort, SortField and SortOrder are the single key used when it is empty
+    Sort          []SortKey `json:"sort"`
+    SortField     string    `json:"sort_by"`
+    SortOrder     string    `json:"sort_order"`
+    ID            string    `json:"_id"`
+    VehicleName   string    `json:"vehicle_name"`
+    VehicleModel  string    `json:"vehicle_model"`
+    LicenseNumber string    `json:"license_number"`
+    // the text filters are prefix matches unless another match is set
+    VehicleNameMatch   TextMatch `json:"vehicle_name_match"`
+    VehicleModelMatch  TextMatch `json:"vehicle_model_match"`
+    LicenseNumberMatch TextMatch `json:"license_number_match"`
+    // a vehicle matches if it has any of the statuses and none of the excluded ones
+    VehicleStatuses  []models.VehicleStatus `json:"vehicle_status"`
+    ExcludedStatuses []models.VehicleStatus `json:"excluded_vehicle_status"`
+    // the ranges are inclusive, a nil or zero bound is open
+    MileageMin   *float64  `json:"mileage_min"`
+    MileageMax   *float64  `json:"mileage_max"`
+    CreatedFrom  time.Time `json:"created_from"`
+    CreatedTo    time.Time `json:"created_to"`
+    UpdatedSince time.Time `json:"updated_since"`
+    // archived (soft deleted) vehicles are hidden unless they are requested explicitly
+    IncludeArchived bool `json:"include_archived"`
+    // Fields are the JSON names of the fields to read (see VehicleFields), no fields read the whole vehicle
+    Fields []string `json:"fields"`
+    // Search are the words to search the vehicles' name, model and license number for,
+    // the results are sorted by relevance unless a sort is given
+    Search     string `json:"q"`
+    id         primitive.ObjectID
+    cursor     *vehicleCursor
+    projection bson.M
+    // cursorProjection also reads the sort keys which aren't selected (the cursorFields), a cursor is created from them
+    cursorProjection bson.M
+    cursorFields     []string
+    relevance        bool
+}
+
+func (v *VehicleFilter) ObjectID() primitive.ObjectID {
+    return v.id
+}
+
+// invalidFilter is returned by Build, the filter is reported as an invalid query parameter
+func invalidFilter(field, rule, message string) error {
+    return NewValidationError(
+        fmt.Errorf("%w: %s", ErrInvalidQuery, message),
+        FieldError{Field: field, Rule: rule, Message: message},
+    )
+}
+
+func (v *VehicleFilter) Build() error {
+    if v.Page == 0 {
+        v.Page = 1
+    }
+    if v.PageSize == 0 {
+        v.PageSize = 10
+    }
+    if v.PageSize > 100 {
+        v.PageSize = 100
+    }
+    // relevance isn't a field, so the page after a vehicle can't be queried and there are no cursors
+    v.relevance = v.relevance || (v.Search != "" && len(v.Sort) == 0 && v.SortField == "")
+    if v.relevance && v.Cursor != "" {
+        return invalidFilter("cursor", "excluded_with", "cursor can't be used with the relevance sort of q")
+    }
+    if v.SortField == "" {
+        v.SortField = "created_at"
+    }
+    if v.SortOrder == "" {
+        v.SortOrder = "asc"
+    }
+    if v.SortOrder != "asc" && v.SortOrder != "desc" {
+        return invalidFilter("sort_order", "oneof", "sort_order must be asc or desc")
+    }
+    sortParam := "sort"
+    if len(v.Sort) == 0 {
+        sortParam = "sort_by"
+        v.Sort = []SortKey{{Field: v.SortField, Descending: v.SortOrder == "desc"}}
+    }
+    for i, key := range v.Sort {
+        if slices.ContainsFunc(
+            v.Sort[:i], func(previous SortKey) bool {
+                return previous.Field == key.Field
+            },
+        ) {
+            return invalidFilter("sort", "unique", fmt.Sprintf("%s is sorted by more than once", key.Field))
+        }
+    }
+    if !supportedSort(v.Sort) {
+        return invalidFilter(
+            sortParam,
+            "oneof",
+            fmt.Sprintf(
+                "the sort is not supported, sort by one of %s, all ascending or all descending",
+                supportedSorts(),
+            ),
+        )
+    }
+    for field, match := range map[string]TextMatch{
+        "vehicle_name":   v.VehicleNameMatch,
+        "vehicle_model":  v.VehicleModelMatch,
+        "license_number": v.LicenseNumberMatch,
+    } {
+        if err := match.Valid(); err != nil {
+            return invalidFilter(field, "match", fmt.Sprintf("%s has an unknown match %s", field, match))
+        }
+    }
+    for _, status := range append(v.VehicleStatuses, v.ExcludedStatuses...) {
+        if err := status.Valid(); err != nil {
+            return invalidFilter("vehicle_status", "oneof", err.Error())
+        }
+    }
+    if v.MileageMin != nil && v.MileageMax != nil && *v.MileageMax < *v.MileageMin {
+        return invalidFilter("mileage_max", "gtefield", "mileage_max must not be less than mileage_min")
+    }
+    if !v.CreatedFrom.IsZero() && !v.CreatedTo.IsZero() && v.CreatedTo.Before(v.CreatedFrom) {
+        return invalidFilter("created_to", "gtefield", "created_to must not be before created_from")
+    }
+    if v.ID != "" {
+        objectID, err := vehicleObjectID(v.ID)
+        if err != nil {
+            return err
+        }
+        v.id = objectID
+    }
+    projection, err := vehicleProjection(v.Fields)
+    if err != nil {
+        return err
+    }
+    v.projection = projection
+    v.cursorProjection, v.cursorFields = projection, nil
+    if projection != nil && !v.relevance {
+        // the cursors of a page are created from the sort keys and _id, they are only read when a cursor is encoded
+        v.cursorProjection = maps.Clone(projection)
+        for _, key := range append(slices.Clone(v.Sort), SortKey{Field: "_id"}) {
+            if projection[key.Field] != 1 {
+                v.cursorProjection[key.Field] = 1
+                v.cursorFields = append(v.cursorFields, key.Field)
+            }
+        }
+    }
+    // the cursor is decoded by the repository, it is signed with the repository's key
+    if v.Cursor != "" && v.Page > 1 {
+        return invalidFilter("cursor", "excluded_with", "cursor can't be combined with page")
+    }
+    return nil
+}
+
+// encodesCursors reports if the page of a built VehicleFilter may need a cursor to the page before or after it,
+// the page of a cursor always does, a numbered page does unless it is the first one and the total shows
+// there are no vehicles after it
+func (v *VehicleFilter) encodesCursors(total *int64) bool {
+    if v.relevance {
+        return false
+    }
+    if v.cursor != nil || v.Page > 1 {
+        return true
+    }
+    return total == nil || *total > int64(v.PageSize)
+}
+
+// sort is the MongoDB sort of a built VehicleFilter, _id is added last, in the order of the other keys,
+// so vehicles with the same values are always listed in the same order and the sort can use its index.
+// The backwards sort reads the list from the end, it is used to find the page before a cursor
+func (v *VehicleFilter) sort(backwards bool) bson.D {
+    if v.relevance {
+        return bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "_id", Value: 1}}
+    }
+    sort := make(bson.D, 0, len(v.Sort)+1)
+    order := 1
+    for _, key := range v.Sort {
+        order = 1
+        if key.Descending != backwards {
+            order = -1
+        }
+        sort = append(sort, bson.E{Key: key.Field, Value: order})
+    }
+    return append(sort, bson.E{Key: "_id", Value: order})
+}
+
+// query is the MongoDB filter of a built VehicleFilter,
+// every vehicle has the archived flag (see migrateArchivedFlag), so it is matched by equality to use the indexes,
+// both values are listed when archived vehicles are included, MongoDB then merges the two sorted index ranges
+func (v *VehicleFilter) query() bson.M {
+    query := bson.M{"archived": false}
+    if v.IncludeArchived {
+        query["archived"] = bson.M{"$in": []bool{false, true}}
+    }
+    if v.ID != "" {
+        query["_id"] = v.id
+    }
+    if v.VehicleName != "" {
+        query["vehicle_name"] = textQuery(v.VehicleName, v.VehicleNameMatch)
+    }
+    if v.VehicleModel != "" {
+        query["vehicle_model"] = textQuery(v.VehicleModel, v.VehicleModelMatch)
+    }
+    if v.LicenseNumber != "" {
+        query["license_number"] = textQuery(v.LicenseNumber, v.LicenseNumberMatch)
+    }
+
+    status := bson.M{}
+    if len(v.VehicleStatuses) > 0 {
+        status["$in"] = v.VehicleStatuses
+    }
+    if len(v.ExcludedStatuses) > 0 {
+        status["$nin"] = v.ExcludedStatuses
+    }
+    if len(status) > 0 {
+        query["vehicle_status"] = status
+    }
+
+    mileage := bson.M{}
+    if v.MileageMin != nil {
+        mileage["$gte"] = *v.MileageMin
+    }
+    if v.MileageMax != nil {
+        mileage["$lte"] = *v.MileageMax
+    }
+    if len(mileage) > 0 {
+        query["mileage"] = mileage
+    }
+
+    createdAt := bson.M{}
+    if !v.CreatedFrom.IsZero() {
+        createdAt["$gte"] = v.CreatedFrom
+    }
+    if !v.CreatedTo.IsZero() {
+        createdAt["$lte"] = v.CreatedTo
+    }
+    if len(createdAt) > 0 {
+        query["created_at"] = createdAt
+    }
+    if !v.UpdatedSince.IsZero() {
+        query["updated_at"] = bson.M{"$gte": v.UpdatedSince}
+    }
+    if v.Search != "" {
+        query["$text"] = bson.M{"$search": searchTerms(v.Search)}
+    }
+    return query
+}
+
+// searchTerms adds the license keys the words could be to the search, so a plate matches with any formatting,
+// e.g. "corolla 4b 12-34" also searches for 1234 (12-34), COROLLA4B, 4B1234 and COROLLA4B1234
+func searchTerms(search string) string {
+    words := strings.Fields(search)
+    terms := slices.Clone(words)
+    for _, word := range words {
+        if key := licenseKey(word); key != strings.ToUpper(word) {
+            terms = append(terms, key)
+        }
+    }
+    // a plate is written in up to maxPlateGroups groups, so the neighbouring words are joined as well
+    for size := 2; size <= maxPlateGroups; size++ {
+        for start := 0; start+size <= len(words); start++ {
+            terms = append(terms, licenseKey(strings.Join(words[start:start+size], "")))
+        }
+    }
+    // the search is a list of words, a quote or a leading - would turn a word into a phrase or a negation
+    for i, term := range terms {
+        terms[i] = strings.TrimLeft(strings.ReplaceAll(term, `"`, ""), "-")
+    }
+    return strings.Join(terms, " ")
+}
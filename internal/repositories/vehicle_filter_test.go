@@ -0,0 +1,152 @@
+package repositories
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestVehicleFilter_Query(t *testing.T) {
+    mileageMin, mileageMax := 100.0, 200.0
+    createdFrom := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+    filter := &VehicleFilter{
+        VehicleName:        "Cor.*",
+        VehicleModelMatch:  TextMatchExact,
+        VehicleModel:       "Corolla",
+        LicenseNumber:      "TEST",
+        LicenseNumberMatch: TextMatchNotContains,
+        VehicleStatuses:    []models.VehicleStatus{models.VehicleStatusActive, models.VehicleStatusRented},
+        ExcludedStatuses:   []models.VehicleStatus{models.VehicleStatusSold},
+        MileageMin:         &mileageMin,
+        MileageMax:         &mileageMax,
+        CreatedFrom:        createdFrom,
+    }
+    if err := filter.Build(); err != nil {
+        t.Fatal(err)
+    }
+
+    want := bson.M{
+        "archived":       false,
+        "vehicle_name":   bson.M{"$regex": `^Cor\.\*`, "$options": "i"},
+        "vehicle_model":  "Corolla",
+        "license_number": bson.M{"$not": primitive.Regex{Pattern: "TEST", Options: "i"}},
+        "vehicle_status": bson.M{
+            "$in":  filter.VehicleStatuses,
+            "$nin": filter.ExcludedStatuses,
+        },
+        "mileage":    bson.M{"$gte": mileageMin, "$lte": mileageMax},
+        "created_at": bson.M{"$gte": createdFrom},
+    }
+    if query := filter.query(); !reflect.DeepEqual(query, want) {
+        t.Fatalf("Query should be %v, got %v", want, query)
+    }
+}
+
+func TestVehicleFilter_Build(t *testing.T) {
+    mileageMin, mileageMax := 200.0, 100.0
+    now := time.Now()
+
+    tests := []*VehicleFilter{
+        {VehicleNameMatch: "fuzzy"},
+        {VehicleStatuses: []models.VehicleStatus{"parked"}},
+        {ExcludedStatuses: []models.VehicleStatus{"parked"}},
+        {MileageMin: &mileageMin, MileageMax: &mileageMax},
+        {CreatedFrom: now, CreatedTo: now.Add(-time.Hour)},
+        {SortField: "password"},
+        {SortOrder: "random"},
+        {Sort: []SortKey{{Field: "mileage"}, {Field: "$where"}}},
+        {Sort: []SortKey{{Field: "mileage"}, {Field: "mileage", Descending: true}}},
+        {SortField: "updated_at"},
+        {Sort: []SortKey{{Field: "created_at"}, {Field: "vehicle_status"}}},
+        {Sort: []SortKey{{Field: "vehicle_status"}, {Field: "created_at", Descending: true}}},
+    }
+
+    for _, filter := range tests {
+        if err := filter.Build(); !errors.Is(err, ErrInvalidQuery) {
+            t.Fatalf("%+v should be an invalid query, got %v", filter, err)
+        }
+    }
+}
+
+func TestVehicleFilter_Sort(t *testing.T) {
+    tests := []struct {
+        filter *VehicleFilter
+        sort   bson.D
+    }{
+        {
+            &VehicleFilter{},
+            bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}},
+        },
+        {
+            &VehicleFilter{SortField: "vehicle_name", SortOrder: "desc"},
+            bson.D{{Key: "vehicle_name", Value: -1}, {Key: "_id", Value: -1}},
+        },
+        {
+            &VehicleFilter{
+                Sort: []SortKey{{Field: "vehicle_status", Descending: true}, {Field: "created_at", Descending: true}},
+            },
+            bson.D{{Key: "vehicle_status", Value: -1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+        },
+    }
+
+    for _, test := range tests {
+        if err := test.filter.Build(); err != nil {
+            t.Fatal(err)
+        }
+        if sort := test.filter.sort(false); !reflect.DeepEqual(sort, test.sort) {
+            t.Fatalf("Sort should be %v, got %v", test.sort, sort)
+        }
+    }
+}
+
+func TestSearchTerms(t *testing.T) {
+    tests := []struct {
+        search string
+        terms  string
+    }{
+        {"corolla", "corolla"},
+        {"4b-1234", "4b-1234 4B1234"},
+        {"corolla 4B 1234", "corolla 4B 1234 COROLLA4B 4B1234 COROLLA4B1234"},
+        {`-sold "corolla"`, "sold corolla SOLD SOLDCOROLLA"},
+    }
+
+    for _, test := range tests {
+        if terms := searchTerms(test.search); terms != test.terms {
+            t.Fatalf("%s should search for %s, got %s", test.search, test.terms, terms)
+        }
+    }
+}
+
+func TestVehicleFilter_Relevance(t *testing.T) {
+    filter := &VehicleFilter{Search: "corolla"}
+    if err := filter.Build(); err != nil {
+        t.Fatal(err)
+    }
+
+    want := bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "_id", Value: 1}}
+    if sort := filter.sort(false); !reflect.DeepEqual(sort, want) {
+        t.Fatalf("Search should be sorted by relevance, got %v", sort)
+    }
+    if filter.query()["$text"] == nil {
+        t.Fatal("Search should be a text query")
+    }
+
+    filter = &VehicleFilter{Search: "corolla", SortField: "mileage"}
+    if err := filter.Build(); err != nil {
+        t.Fatal(err)
+    }
+    if filter.relevance {
+        t.Fatal("Given sort should be used instead of the relevance")
+    }
+
+    filter = &VehicleFilter{Search: "corolla", Cursor: "cursor"}
+    if err := filter.Build(); !errors.Is(err, ErrInvalidQuery) {
+        t.Fatal("Cursor should not be used with the relevance sort")
+    }
+}
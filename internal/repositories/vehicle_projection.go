@@ -0,0 +1,66 @@
+package repositories
+
+import (
+    "fmt"
+    "maps"
+    "slices"
+    "strings"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// VehicleFields maps the fields of a vehicle which can be selected (by their JSON name) to their stored name
+var VehicleFields = map[string]string{
+    "id":             "_id",
+    "vehicle_name":   "vehicle_name",
+    "vehicle_model":  "vehicle_model",
+    "vehicle_status": "vehicle_status",
+    "mileage":        "mileage",
+    "license_number": "license_number",
+    "created_at":     "created_at",
+    "updated_at":     "updated_at",
+    "deleted_at":     "deleted_at",
+}
+
+// vehicleProjection is the MongoDB projection of the selected fields, no fields select the whole vehicle.
+// MongoDB reads _id unless it is excluded, so it is excluded when id isn't selected
+func vehicleProjection(fields []string) (bson.M, error) {
+    if len(fields) == 0 {
+        return nil, nil
+    }
+
+    projection := bson.M{"_id": 0}
+    for _, field := range fields {
+        stored, ok := VehicleFields[field]
+        if !ok {
+            message := fmt.Sprintf(
+                "%s is not a vehicle field, select %s",
+                field,
+                strings.Join(slices.Sorted(maps.Keys(VehicleFields)), ", "),
+            )
+            return nil, invalidFilter("fields", "oneof", message)
+        }
+        projection[stored] = 1
+    }
+    return projection, nil
+}
+
+// withoutFields returns the document without the given stored fields,
+// they were only read for the query (e.g. the sort keys of a cursor) and aren't selected
+func withoutFields(document bson.Raw, fields []string) (bson.Raw, error) {
+    if len(fields) == 0 {
+        return document, nil
+    }
+    elements, err := document.Elements()
+    if err != nil {
+        return nil, err
+    }
+    kept := make([][]byte, 0, len(elements))
+    for _, element := range elements {
+        if !slices.Contains(fields, element.Key()) {
+            kept = append(kept, element)
+        }
+    }
+    return bsoncore.BuildDocument(nil, kept...), nil
+}
@@ -0,0 +1,103 @@
+package repositories
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+func TestVehicleProjection(t *testing.T) {
+    projection, err := vehicleProjection(nil)
+    if err != nil || projection != nil {
+        t.Fatal("No fields should read the whole vehicle")
+    }
+
+    projection, err = vehicleProjection([]string{"id", "vehicle_name"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := bson.M{"_id": 1, "vehicle_name": 1}
+    if !reflect.DeepEqual(projection, want) {
+        t.Fatalf("Projection should be %v, got %v", want, projection)
+    }
+
+    projection, err = vehicleProjection([]string{"vehicle_name"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    want = bson.M{"_id": 0, "vehicle_name": 1}
+    if !reflect.DeepEqual(projection, want) {
+        t.Fatalf("Projection should exclude the unselected _id, got %v", projection)
+    }
+
+    if _, err := vehicleProjection([]string{"vehicle_name", "archived"}); !errors.Is(err, ErrInvalidQuery) {
+        t.Fatal("Unknown field should be an invalid query")
+    }
+}
+
+func TestVehicleFilter_BuildProjection(t *testing.T) {
+    filter := &VehicleFilter{
+        Fields: []string{"id", "vehicle_status"},
+        Sort:   []SortKey{{Field: "mileage", Descending: true}},
+    }
+    if err := filter.Build(); err != nil {
+        t.Fatal(err)
+    }
+
+    want := bson.M{"_id": 1, "vehicle_status": 1}
+    if !reflect.DeepEqual(filter.projection, want) {
+        t.Fatalf("Projection should only read the selected fields, got %v", filter.projection)
+    }
+    want = bson.M{"_id": 1, "vehicle_status": 1, "mileage": 1}
+    if !reflect.DeepEqual(filter.cursorProjection, want) {
+        t.Fatalf("Cursor projection should read the sort keys, got %v", filter.cursorProjection)
+    }
+    if !reflect.DeepEqual(filter.cursorFields, []string{"mileage"}) {
+        t.Fatalf("Only the unselected sort keys should be removed again, got %v", filter.cursorFields)
+    }
+}
+
+func TestVehicleFilter_EncodesCursors(t *testing.T) {
+    filter := &VehicleFilter{PageSize: 10}
+    if err := filter.Build(); err != nil {
+        t.Fatal(err)
+    }
+    total := int64(10)
+    if filter.encodesCursors(&total) {
+        t.Fatal("The only page should not encode cursors")
+    }
+    total = 11
+    if !filter.encodesCursors(&total) || !filter.encodesCursors(nil) {
+        t.Fatal("A first page with vehicles after it should encode a cursor")
+    }
+
+    filter = &VehicleFilter{Search: "corolla"}
+    if err := filter.Build(); err != nil {
+        t.Fatal(err)
+    }
+    if filter.encodesCursors(nil) {
+        t.Fatal("The relevance sort should not encode cursors")
+    }
+}
+
+func TestWithoutFields(t *testing.T) {
+    document, err := bson.Marshal(
+        bson.D{{Key: "_id", Value: 1}, {Key: "vehicle_status", Value: "active"}, {Key: "mileage", Value: 10.5}},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    selected, err := withoutFields(document, []string{"_id", "mileage"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got bson.M
+    if err := bson.Unmarshal(selected, &got); err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(got, bson.M{"vehicle_status": "active"}) {
+        t.Fatalf("Only the selected fields should be kept, got %v", got)
+    }
+}
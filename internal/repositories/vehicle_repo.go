@@ -2,78 +2,108 @@ package repositories
 
 import (
     "context"
+    "errors"
     "fmt"
-    "log"
+    "log/slog"
+    "slices"
+    "strings"
     "time"
 
     "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/metrics"
     "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/bson/primitive"
     "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
+    "go.opentelemetry.io/otel"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+    "go.opentelemetry.io/otel/trace"
 )
 
-type VehicleFilter struct {
-    Page          int                  `json:"page"`
-    PageSize      int                  `json:"limit"`
-    SortField     string               `json:"sort_by"`
-    SortOrder     string               `json:"sort_order"`
-    ID            string               `json:"_id"`
-    VehicleName   string               `json:"vehicle_name"`
-    VehicleModel  string               `json:"vehicle_model"`
-    LicenseNumber string               `json:"license_number"`
-    VehicleStatus models.VehicleStatus `json:"vehicle_status"`
-    Mileage       float64              `json:"mileage"`
-    id            primitive.ObjectID
+// tracer starts the spans of the MongoDB and RabbitMQ operations
+var tracer = otel.Tracer("github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories")
+
+var (
+    ErrVehicleNotFound        = NewError(KindNotFound, "vehicle_not_found", "vehicle not found")
+    ErrInvalidVehicleID       = NewError(KindInvalidID, "invalid_vehicle_id", "invalid vehicle id")
+    ErrDuplicateLicenseNumber = NewError(KindConflict, "duplicate_license_number", "license number already exists")
+    ErrVehicleNotArchived     = NewError(KindConflict, "vehicle_not_archived", "vehicle is not archived")
+    ErrMileageDecreased       = NewError(KindConflict, "mileage_decreased", "mileage must not decrease")
+    ErrVehicleStatusChanged   = NewError(KindConflict, "vehicle_status_changed", "vehicle status changed meanwhile")
+    ErrInvalidQuery           = NewError(KindInvalidArgument, "invalid_query", "invalid query parameter")
+)
+
+const (
+    // legacyLicenseIndex is the name of the old unique index that also covered archived vehicles
+    legacyLicenseIndex = "license_number_1"
+    activeLicenseIndex = "license_number_active"
+    vehicleTextIndex   = "vehicle_text"
+    // defaultExactCountLimit is used when the limit isn't set with SetExactCountLimit
+    defaultExactCountLimit = 10000
+)
+
+// vehicleDocument is the stored form of a vehicle,
+// archived is kept next to deleted_at because a partial index can only match on equality,
+// so the unique license index can skip archived vehicles
+type vehicleDocument struct {
+    models.Vehicle `bson:",inline"`
+    Archived       bool `bson:"archived"`
+    // LicenseKey is the license number without its formatting (see licenseKey), it is part of the text index
+    // so a plate is found however it is typed
+    LicenseKey string `bson:"license_key"`
 }
 
-func (v *VehicleFilter) ObjectID() primitive.ObjectID {
-    return v.id
+// licensePlateFormatting are the characters a license number is written with but which don't identify the plate
+var licensePlateFormatting = strings.NewReplacer(" ", "", "-", "")
+
+// licenseKey normalises the license number, e.g. "4b-12 34" is 4B1234
+func licenseKey(licenseNumber string) string {
+    return strings.ToUpper(licensePlateFormatting.Replace(licenseNumber))
 }
 
-func (v *VehicleFilter) Build() error {
-    if v.Page == 0 {
-        v.Page = 1
-    }
-    if v.PageSize == 0 {
-        v.PageSize = 10
-    }
-    if v.PageSize > 100 {
-        v.PageSize = 100
-    }
-    if v.SortField == "" {
-        v.SortField = "created_at"
-    }
-    if v.SortOrder == "" {
-        v.SortOrder = "asc"
-    }
-    if v.VehicleStatus != "" {
-        if err := v.VehicleStatus.Valid(); err != nil {
-            return err
-        }
-    }
-    if v.ID != "" {
-        objectID, err := primitive.ObjectIDFromHex(v.ID)
-        if err != nil {
-            return err
-        }
-        v.id = objectID
-    }
-    return nil
+// MileageAnomaly is a tracking reading that was rejected instead of being applied to the vehicle,
+// or a mileage reset which replaced the baseline the readings are checked against
+type MileageAnomaly struct {
+    ID              primitive.ObjectID   `json:"id,omitempty" bson:"_id,omitempty"`
+    VehicleID       primitive.ObjectID   `json:"vehicle_id" bson:"vehicle_id"`
+    ReportedMileage float64              `json:"reported_mileage" bson:"reported_mileage"`
+    CurrentMileage  float64              `json:"current_mileage" bson:"current_mileage"`
+    ReportedStatus  models.VehicleStatus `json:"reported_status" bson:"reported_status"`
+    Reason          string               `json:"reason" bson:"reason"`
+    CreatedAt       time.Time            `json:"created_at" bson:"created_at"`
 }
 
 type VehicleRepository interface {
     CreateVehicle(ctx context.Context, vehicle *models.Vehicle) error
-    TrackingVehicle(ctx context.Context, id string, mileAge float64, status models.VehicleStatus) error
+    TrackingVehicle(
+        ctx context.Context,
+        id string,
+        mileAge float64,
+        status models.VehicleStatus,
+        currentStatus models.VehicleStatus,
+    ) error
     FindVehicles(
         ctx context.Context,
         filter *VehicleFilter,
     ) ([]*models.Vehicle, error)
-    FindVehicleByID(ctx context.Context, id string, vehicle *models.Vehicle) error
+    FindVehiclePage(ctx context.Context, filter *VehicleFilter) (*VehiclePage, error)
+    FindVehicleByID(ctx context.Context, id string, vehicle *models.Vehicle, fields ...string) error
+    UpdateVehicle(ctx context.Context, vehicle *models.Vehicle, currentStatus models.VehicleStatus) error
+    ResetMileage(ctx context.Context, id string, mileAge float64, reason string) error
+    DeleteVehicle(ctx context.Context, id string) error
+    RestoreVehicle(ctx context.Context, id string) error
 }
 
 type MongoVehicleRepository struct {
     collection *mongo.Collection
+    anomalies  *mongo.Collection
+    // readings lower than the stored mileage by at most this tolerance are accepted (e.g. rounding, GPS jitter)
+    // but the stored mileage is kept, so the odometer never goes backwards
+    mileageTolerance float64
+    // exactCountLimit is how many matching vehicles are counted, a larger total is estimated
+    exactCountLimit int64
+    // cursorKey signs the cursors of the pages, so the clients can't alter them
+    cursorKey []byte
 }
 
 func NewMongoVehicleRepository(ctx context.Context, db *mongo.Database) (*MongoVehicleRepository, error) {
@@ -82,49 +112,239 @@ func NewMongoVehicleRepository(ctx context.Context, db *mongo.Database) (*MongoV
     ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
     defer cancel()
 
-    indexModel := mongo.IndexModel{
-        Keys:    bson.M{"license_number": 1},
-        Options: options.Index().SetUnique(true),
+    if err := migrateArchivedFlag(ctx, vehiclesCollection); err != nil {
+        return nil, err
     }
+    if err := migrateLicenseKey(ctx, vehiclesCollection); err != nil {
+        return nil, err
+    }
+    if err := dropUnusedSortIndexes(ctx, vehiclesCollection); err != nil {
+        return nil, err
+    }
+
+    // license numbers only have to be unique among active vehicles,
+    // so a plate can be registered again after the previous vehicle was archived
+    indexModels := []mongo.IndexModel{
+        {
+            Keys: bson.M{"license_number": 1},
+            Options: options.Index().
+                SetName(activeLicenseIndex).
+                SetUnique(true).
+                SetPartialFilterExpression(bson.M{"archived": false}),
+        },
+    }
+    // every supported sort has an index which serves the list of vehicles sorted by it (in both orders),
+    // _id is the tie-breaker the list is sorted by last
+    for _, fields := range SortIndexes {
+        keys := bson.D{{Key: "archived", Value: 1}}
+        for _, field := range fields {
+            keys = append(keys, bson.E{Key: field, Value: 1})
+        }
+        indexModels = append(indexModels, mongo.IndexModel{Keys: append(keys, bson.E{Key: "_id", Value: 1})})
+    }
+
+    // the search (q) matches the words of the name, the model and the license number, a plate match ranks highest
+    indexModels = append(
+        indexModels, mongo.IndexModel{
+            Keys: bson.D{
+                {Key: "vehicle_name", Value: "text"},
+                {Key: "vehicle_model", Value: "text"},
+                {Key: "license_number", Value: "text"},
+                {Key: "license_key", Value: "text"},
+            },
+            Options: options.Index().
+                SetName(vehicleTextIndex).
+                SetWeights(bson.M{"vehicle_name": 2, "vehicle_model": 2, "license_number": 5, "license_key": 5}),
+        },
+    )
 
-    _, err := vehiclesCollection.Indexes().CreateOne(ctx, indexModel)
+    _, err := vehiclesCollection.Indexes().CreateMany(ctx, indexModels)
     if err != nil {
         return nil, err
     }
     return &MongoVehicleRepository{
-        collection: vehiclesCollection,
+        collection:      vehiclesCollection,
+        anomalies:       db.Collection("mileage_anomalies"),
+        exactCountLimit: defaultExactCountLimit,
     }, nil
 }
 
+// SetMileageTolerance sets how far below the stored mileage a reading may be before it is rejected,
+// a replaced or rolled over odometer is given a new baseline with ResetMileage
+func (repo *MongoVehicleRepository) SetMileageTolerance(tolerance float64) *MongoVehicleRepository {
+    repo.mileageTolerance = tolerance
+    return repo
+}
+
+// SetExactCountLimit sets how many matching vehicles are counted exactly,
+// counting stops at the limit, so a list matching more vehicles doesn't scan all of them
+func (repo *MongoVehicleRepository) SetExactCountLimit(limit int64) *MongoVehicleRepository {
+    repo.exactCountLimit = limit
+    return repo
+}
+
+// SetCursorKey sets the key the cursors of the pages are signed with,
+// the cursors signed with a previous key are rejected
+func (repo *MongoVehicleRepository) SetCursorKey(key string) *MongoVehicleRepository {
+    repo.cursorKey = []byte(key)
+    return repo
+}
+
+// vehicleObjectID parses the ID of a vehicle, it returns ErrInvalidVehicleID if it isn't an ObjectID
+func vehicleObjectID(id string) (primitive.ObjectID, error) {
+    objectID, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return primitive.NilObjectID, ErrInvalidVehicleID
+    }
+    return objectID, nil
+}
+
+// startOperation starts the span of a MongoDB operation,
+// the returned function ends it and records the operation's duration
+func (repo *MongoVehicleRepository) startOperation(ctx context.Context, operation string) (context.Context, func()) {
+    start := time.Now()
+    ctx, span := tracer.Start(
+        ctx,
+        "mongo "+operation,
+        trace.WithSpanKind(trace.SpanKindClient),
+        trace.WithAttributes(
+            semconv.DBSystemMongoDB,
+            semconv.DBNamespace(repo.collection.Database().Name()),
+            semconv.DBCollectionName(repo.collection.Name()),
+            semconv.DBOperationName(operation),
+        ),
+    )
+    return ctx, func() {
+        span.End()
+        metrics.ObserveMongoOperation(operation, start)
+    }
+}
+
+// migrateLicenseKey backfills the license key of the vehicles created before the search existed,
+// it normalises the license numbers the same way as licenseKey
+func migrateLicenseKey(ctx context.Context, collection *mongo.Collection) error {
+    normalised := any(bson.M{"$toUpper": "$license_number"})
+    for _, formatting := range []string{" ", "-"} {
+        normalised = bson.M{"$replaceAll": bson.M{"input": normalised, "find": formatting, "replacement": ""}}
+    }
+    _, err := collection.UpdateMany(
+        ctx,
+        bson.M{"license_key": bson.M{"$exists": false}},
+        mongo.Pipeline{{{Key: "$set", Value: bson.M{"license_key": normalised}}}},
+    )
+    return err
+}
+
+// migrateArchivedFlag backfills the archived flag for vehicles created before soft delete existed
+// and drops the legacy unique index which would still block re-registering an archived plate
+func migrateArchivedFlag(ctx context.Context, collection *mongo.Collection) error {
+    _, err := collection.UpdateMany(
+        ctx,
+        bson.M{"archived": bson.M{"$exists": false}, "deleted_at": bson.M{"$exists": false}},
+        bson.M{"$set": bson.M{"archived": false}},
+    )
+    if err != nil {
+        return err
+    }
+    _, err = collection.UpdateMany(
+        ctx,
+        bson.M{"archived": bson.M{"$exists": false}},
+        bson.M{"$set": bson.M{"archived": true}},
+    )
+    if err != nil {
+        return err
+    }
+
+    specs, err := collection.Indexes().ListSpecifications(ctx)
+    if err != nil {
+        return err
+    }
+    for _, spec := range specs {
+        if spec.Name == legacyLicenseIndex {
+            if _, err := collection.Indexes().DropOne(ctx, legacyLicenseIndex); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// unusedSortIndexes are the indexes of the sorts which are no longer supported (see SortIndexes),
+// they would only slow down the writes
+var unusedSortIndexes = []string{
+    "archived_1_updated_at_1__id_1",
+    "archived_1_vehicle_model_1__id_1",
+    "archived_1_vehicle_status_1__id_1",
+}
+
+// dropUnusedSortIndexes drops the unusedSortIndexes created by the previous versions
+func dropUnusedSortIndexes(ctx context.Context, collection *mongo.Collection) error {
+    specs, err := collection.Indexes().ListSpecifications(ctx)
+    if err != nil {
+        return err
+    }
+    for _, spec := range specs {
+        if slices.Contains(unusedSortIndexes, spec.Name) {
+            if _, err := collection.Indexes().DropOne(ctx, spec.Name); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
 func (repo *MongoVehicleRepository) CreateVehicle(ctx context.Context, vehicle *models.Vehicle) error {
+    ctx, end := repo.startOperation(ctx, "create_vehicle")
+    defer end()
+
     if err := vehicle.Build(); err != nil {
         return err
     }
-    result, err := repo.collection.InsertOne(ctx, vehicle)
+    result, err := repo.collection.InsertOne(
+        ctx,
+        vehicleDocument{Vehicle: *vehicle, LicenseKey: licenseKey(vehicle.LicenseNumber)},
+    )
     if err != nil {
+        if mongo.IsDuplicateKeyError(err) {
+            return ErrDuplicateLicenseNumber
+        }
         return err
     }
     vehicle.ID = result.InsertedID.(primitive.ObjectID)
     return nil
 }
 
+// TrackingVehicle applies a tracking reading to the vehicle,
+// the update only matches when the reading doesn't roll the odometer back (beyond the tolerance),
+// otherwise the reading is recorded as an anomaly and ErrMileageDecreased is returned.
+// The status transition was checked against currentStatus, so the update also only matches while the vehicle
+// is still in it, ErrVehicleStatusChanged is returned when another update changed it in the meantime
 func (repo *MongoVehicleRepository) TrackingVehicle(
     ctx context.Context,
     id string,
     mileAge float64,
     status models.VehicleStatus,
+    currentStatus models.VehicleStatus,
 ) error {
-    objectID, err := primitive.ObjectIDFromHex(id)
+    ctx, end := repo.startOperation(ctx, "tracking_vehicle")
+    defer end()
+
+    objectID, err := vehicleObjectID(id)
     if err != nil {
         return err
     }
-    updateResult, err := repo.collection.UpdateByID(
+    updateResult, err := repo.collection.UpdateOne(
         ctx,
-        objectID,
         bson.M{
-            // should use $inc for incrementing mileage, but for the sake of example, we use $set
+            "_id":            objectID,
+            "archived":       bson.M{"$ne": true},
+            "vehicle_status": currentStatus,
+            "mileage":        bson.M{"$lte": mileAge + repo.mileageTolerance},
+        },
+        bson.M{
+            // $max keeps the stored mileage when the reading is within the tolerance but lower
+            "$max": bson.M{"mileage": mileAge},
             "$set": bson.M{
-                "mileage":        mileAge,
                 "vehicle_status": status,
             },
             "$currentDate": bson.M{"updated_at": true},
@@ -134,58 +354,154 @@ func (repo *MongoVehicleRepository) TrackingVehicle(
         return err
     }
     if updateResult.MatchedCount == 0 {
-        return fmt.Errorf("vehicle not found")
+        return repo.rejectReading(ctx, objectID, mileAge, status, currentStatus)
     }
-    if updateResult.UpsertedID != nil {
-        if updateResult.UpsertedID.(primitive.ObjectID) != objectID {
-            return fmt.Errorf("vehicle not found")
+    return nil
+}
+
+// rejectReading is called when the conditional tracking update didn't match,
+// either the vehicle doesn't exist, its status was changed or the reading would decrease the mileage
+func (repo *MongoVehicleRepository) rejectReading(
+    ctx context.Context,
+    objectID primitive.ObjectID,
+    mileAge float64,
+    status models.VehicleStatus,
+    currentStatus models.VehicleStatus,
+) error {
+    var vehicle models.Vehicle
+    err := repo.collection.FindOne(
+        ctx,
+        bson.M{"_id": objectID, "archived": bson.M{"$ne": true}},
+    ).Decode(&vehicle)
+    if err != nil {
+        if errors.Is(err, mongo.ErrNoDocuments) {
+            return ErrVehicleNotFound
         }
+        return err
     }
-    return nil
+    if vehicle.VehicleStatus != currentStatus {
+        return ErrVehicleStatusChanged
+    }
+    anomaly := MileageAnomaly{
+        VehicleID:       objectID,
+        ReportedMileage: mileAge,
+        CurrentMileage:  vehicle.Mileage,
+        ReportedStatus:  status,
+        Reason: fmt.Sprintf(
+            "reported mileage %.2f is lower than current mileage %.2f (tolerance %.2f)",
+            mileAge,
+            vehicle.Mileage,
+            repo.mileageTolerance,
+        ),
+        CreatedAt: time.Now(),
+    }
+    if _, err := repo.anomalies.InsertOne(ctx, anomaly); err != nil {
+        return err
+    }
+    return fmt.Errorf("%w: %s", ErrMileageDecreased, anomaly.Reason)
 }
 
+// VehiclePage is a page of the vehicle list,
+// the cursors are the positions of its first and last vehicle and they are empty when there is no page before or after
+type VehiclePage struct {
+    Vehicles   []*models.Vehicle
+    NextCursor string
+    PrevCursor string
+    // HasNext is set when there are vehicles after the page, even if there is no cursor to them
+    HasNext bool
+    // Page is the number of the page, it is 0 when the page was selected by a cursor
+    Page     int
+    PageSize int
+    // Total is the number of matching vehicles, it is estimated when TotalEstimated is set.
+    // It is nil when the vehicles weren't counted, the pages selected by a cursor aren't counted,
+    // and when there are more than the exact count limit which can't be estimated
+    Total          *int64
+    TotalEstimated bool
+}
+
+// TotalPages is the number of pages of the list, it is nil unless the total was counted exactly
+func (p *VehiclePage) TotalPages() *int64 {
+    if p.Total == nil || p.TotalEstimated {
+        return nil
+    }
+    var totalPages int64
+    if p.PageSize > 0 {
+        totalPages = (*p.Total + int64(p.PageSize) - 1) / int64(p.PageSize)
+    }
+    return &totalPages
+}
+
+// FindVehicles returns the vehicles of the page, see FindVehiclePage
 func (repo *MongoVehicleRepository) FindVehicles(
     ctx context.Context,
     filter *VehicleFilter,
 ) ([]*models.Vehicle, error) {
-    var vehicles []*models.Vehicle
+    page, err := repo.FindVehiclePage(ctx, filter)
+    if err != nil {
+        return nil, err
+    }
+    return page.Vehicles, nil
+}
 
-    bsonMFilter := bson.M{}
-    findOptions := options.Find()
+// FindVehiclePage returns a page of the vehicles which match the filter,
+// the page is the one after (or before) the filter's cursor, or the numbered page when there is no cursor
+func (repo *MongoVehicleRepository) FindVehiclePage(
+    ctx context.Context,
+    filter *VehicleFilter,
+) (*VehiclePage, error) {
+    ctx, end := repo.startOperation(ctx, "find_vehicles")
+    defer end()
 
-    if filter != nil {
-        if err := filter.Build(); err != nil {
+    if filter == nil {
+        filter = &VehicleFilter{}
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    if filter.Cursor != "" {
+        cursor, err := decodeVehicleCursor(filter.Cursor, filter.Sort, repo.cursorKey)
+        if err != nil {
             return nil, err
         }
-        if filter.ID != "" {
-            bsonMFilter["_id"] = filter.ObjectID()
-        }
-        if filter.VehicleName != "" {
-            bsonMFilter["vehicle_name"] = bson.M{"$regex": fmt.Sprintf("^%s", filter.VehicleName), "$options": "i"}
-        }
-        if filter.VehicleModel != "" {
-            bsonMFilter["vehicle_model"] = bson.M{"$regex": fmt.Sprintf("^%s", filter.VehicleModel), "$options": "i"}
-        }
-        if filter.VehicleStatus != "" {
-            bsonMFilter["vehicle_status"] = filter.VehicleStatus
-        }
-        if filter.Mileage != 0 {
-            bsonMFilter["mileage"] = bson.M{"$gte": filter.Mileage}
-        }
-        if filter.LicenseNumber != "" {
-            bsonMFilter["license_number"] = bson.M{"$regex": fmt.Sprintf("^%s", filter.LicenseNumber), "$options": "i"}
-        }
+        filter.cursor = cursor
+    }
 
-        if filter.SortField != "" {
-            order := 1
-            if filter.SortOrder == "desc" {
-                order = -1
-            }
-            findOptions.SetSort(bson.D{{Key: filter.SortField, Value: order}})
+    // the page before a cursor is read backwards from it, and reversed below
+    before := filter.cursor != nil && filter.cursor.Before
+
+    bsonMFilter := filter.query()
+    // one more vehicle than the page size is fetched to find out if there is a page after it
+    findOptions := options.Find().
+        SetSort(filter.sort(before)).
+        SetLimit(int64(filter.PageSize) + 1)
+
+    page := &VehiclePage{PageSize: filter.PageSize}
+
+    // the numbered pages are counted, the cursors are there to page through large lists,
+    // so they aren't counted again on every page
+    var err error
+    if filter.cursor == nil {
+        if page.Total, page.TotalEstimated, err = repo.countVehicles(ctx, bsonMFilter); err != nil {
+            return nil, err
         }
+    }
 
+    if filter.cursor != nil {
+        bsonMFilter["$or"] = filter.cursor.query(filter.Sort)
+    } else {
+        page.Page = filter.Page
         findOptions.SetSkip(int64((filter.Page - 1) * filter.PageSize))
-        findOptions.SetLimit(int64(filter.PageSize))
+    }
+
+    // the sort keys which aren't selected are only read when a cursor to the page before or after is encoded,
+    // they are removed again from the vehicles, so only the selected fields are returned
+    cursors := filter.encodesCursors(page.Total)
+    projection, unselected := filter.projection, []string(nil)
+    if cursors {
+        projection, unselected = filter.cursorProjection, filter.cursorFields
+    }
+    if projection != nil {
+        findOptions.SetProjection(projection)
     }
 
     cursor, err := repo.collection.Find(ctx, bsonMFilter, findOptions)
@@ -195,36 +511,321 @@ func (repo *MongoVehicleRepository) FindVehicles(
     defer func(cursor *mongo.Cursor, ctx context.Context) {
         err := cursor.Close(ctx)
         if err != nil {
-            log.Println("Failed to close cursor", err)
+            slog.ErrorContext(ctx, "Failed to close cursor", slog.Any("error", err))
         }
     }(cursor, ctx)
 
+    var documents []bson.Raw
     for cursor.Next(ctx) {
+        // Current is only valid until the next call to Next
+        documents = append(documents, slices.Clone(cursor.Current))
+    }
+    if err := cursor.Err(); err != nil {
+        return nil, err
+    }
+
+    more := len(documents) > filter.PageSize
+    if more {
+        documents = documents[:filter.PageSize]
+    }
+    if before {
+        slices.Reverse(documents)
+    }
+
+    page.Vehicles = make([]*models.Vehicle, 0, len(documents))
+    for _, document := range documents {
+        selected, err := withoutFields(document, unselected)
+        if err != nil {
+            return nil, err
+        }
         var vehicle models.Vehicle
-        if err := cursor.Decode(&vehicle); err != nil {
+        if err := bson.Unmarshal(selected, &vehicle); err != nil {
+            return nil, err
+        }
+        page.Vehicles = append(page.Vehicles, &vehicle)
+    }
+    // the relevance of a search isn't stored, so there are no cursors to the pages around,
+    // and the only page has none either (a vehicle created since it was counted is on the next request's page)
+    if len(documents) == 0 || !cursors {
+        page.HasNext = more
+        return page, nil
+    }
+
+    // reading forwards, there is a page before unless this is the first one, and a page after if a vehicle was left,
+    // reading backwards it is the other way around
+    hasPrev, hasNext := filter.cursor != nil || filter.Page > 1, more
+    if before {
+        hasPrev, hasNext = more, true
+    }
+    page.HasNext = hasNext
+    if hasPrev {
+        if page.PrevCursor, err = encodeVehicleCursor(documents[0], filter.Sort, true, repo.cursorKey); err != nil {
+            return nil, err
+        }
+    }
+    if hasNext {
+        last := documents[len(documents)-1]
+        if page.NextCursor, err = encodeVehicleCursor(last, filter.Sort, false, repo.cursorKey); err != nil {
             return nil, err
         }
-        vehicles = append(vehicles, &vehicle)
+    }
+    return page, nil
+}
+
+// countVehicles counts the vehicles which match the query, counting stops at the exact count limit.
+// Above the limit the total is estimated by the collection's metadata when only the archived flag is filtered
+// (it includes the archived vehicles), otherwise only a lower bound is known and there is no total
+func (repo *MongoVehicleRepository) countVehicles(ctx context.Context, query bson.M) (*int64, bool, error) {
+    countOptions := options.Count()
+    if repo.exactCountLimit > 0 {
+        countOptions.SetLimit(repo.exactCountLimit)
+    }
+    count, err := repo.collection.CountDocuments(ctx, query, countOptions)
+    if err != nil {
+        return nil, false, err
+    }
+    if repo.exactCountLimit <= 0 || count < repo.exactCountLimit {
+        return &count, false, nil
     }
 
-    return vehicles, nil
+    if len(query) == 0 || (len(query) == 1 && query["archived"] == false) {
+        estimated, err := repo.collection.EstimatedDocumentCount(ctx)
+        if err != nil {
+            return nil, false, err
+        }
+        estimated = max(count, estimated)
+        return &estimated, true, nil
+    }
+    return nil, false, nil
 }
 
+// FindVehicleByID reads the vehicle, only the given fields (see VehicleFields) are read when there are any
 func (repo *MongoVehicleRepository) FindVehicleByID(
     ctx context.Context,
     id string,
     vehicle *models.Vehicle,
+    fields ...string,
 ) error {
-    objID, err := primitive.ObjectIDFromHex(id)
+    ctx, end := repo.startOperation(ctx, "find_vehicle_by_id")
+    defer end()
+
+    objID, err := vehicleObjectID(id)
     if err != nil {
         return err
     }
-    err = repo.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(vehicle)
+    projection, err := vehicleProjection(fields)
     if err != nil {
         return err
     }
+    findOptions := options.FindOne()
+    if projection != nil {
+        findOptions.SetProjection(projection)
+    }
+    err = repo.collection.FindOne(ctx, bson.M{"_id": objID}, findOptions).Decode(vehicle)
+    if err != nil {
+        if errors.Is(err, mongo.ErrNoDocuments) {
+            return ErrVehicleNotFound
+        }
+        return err
+    }
+    // a partly read vehicle can't be checked
+    if projection != nil {
+        return nil
+    }
     if err := vehicle.Check(); err != nil {
         return err
     }
     return nil
 }
+
+// UpdateVehicle replaces the editable fields of an existing vehicle,
+// created_at is kept as it is and updated_at is refreshed by Build,
+// archived vehicles have to be restored before they can be updated.
+// The update only matches while the vehicle is in currentStatus (the status its transition was checked against),
+// ErrVehicleStatusChanged is returned when another update changed it in the meantime.
+// The mileage is never lowered by an update (see ResetMileage), ErrMileageDecreased is returned when the stored
+// mileage is higher, e.g. a tracking reading was applied after the vehicle was read
+func (repo *MongoVehicleRepository) UpdateVehicle(
+    ctx context.Context,
+    vehicle *models.Vehicle,
+    currentStatus models.VehicleStatus,
+) error {
+    ctx, end := repo.startOperation(ctx, "update_vehicle")
+    defer end()
+
+    if vehicle.ID.IsZero() {
+        return models.ErrIDMissing
+    }
+    if err := vehicle.Build(); err != nil {
+        return err
+    }
+    updateResult, err := repo.collection.UpdateOne(
+        ctx,
+        bson.M{
+            "_id":            vehicle.ID,
+            "archived":       bson.M{"$ne": true},
+            "vehicle_status": currentStatus,
+            "mileage":        bson.M{"$lte": vehicle.Mileage},
+        },
+        bson.M{
+            "$set": bson.M{
+                "vehicle_name":   vehicle.VehicleName,
+                "vehicle_model":  vehicle.VehicleModel,
+                "vehicle_status": vehicle.VehicleStatus,
+                "mileage":        vehicle.Mileage,
+                "license_number": vehicle.LicenseNumber,
+                "license_key":    licenseKey(vehicle.LicenseNumber),
+                "updated_at":     vehicle.UpdatedAt,
+            },
+        },
+    )
+    if err != nil {
+        if mongo.IsDuplicateKeyError(err) {
+            return ErrDuplicateLicenseNumber
+        }
+        return err
+    }
+    if updateResult.MatchedCount == 0 {
+        var current models.Vehicle
+        err := repo.collection.FindOne(
+            ctx,
+            bson.M{"_id": vehicle.ID, "archived": bson.M{"$ne": true}},
+            options.FindOne().SetProjection(bson.M{"vehicle_status": 1, "mileage": 1}),
+        ).Decode(&current)
+        if err != nil {
+            if errors.Is(err, mongo.ErrNoDocuments) {
+                return ErrVehicleNotFound
+            }
+            return err
+        }
+        if current.VehicleStatus != currentStatus {
+            return ErrVehicleStatusChanged
+        }
+        return fmt.Errorf(
+            "%w: mileage %g is less than the current mileage %g",
+            ErrMileageDecreased,
+            vehicle.Mileage,
+            current.Mileage,
+        )
+    }
+    return nil
+}
+
+// ResetMileage starts a new mileage baseline after the odometer was replaced or rolled over,
+// the mileage is set even if it is lower than the stored one and the reset is recorded as an anomaly with the reason,
+// so the readings of the new odometer are accepted from then on
+func (repo *MongoVehicleRepository) ResetMileage(
+    ctx context.Context,
+    id string,
+    mileAge float64,
+    reason string,
+) error {
+    ctx, end := repo.startOperation(ctx, "reset_mileage")
+    defer end()
+
+    objectID, err := vehicleObjectID(id)
+    if err != nil {
+        return err
+    }
+    // the document before the update has the mileage which is replaced
+    var previous models.Vehicle
+    err = repo.collection.FindOneAndUpdate(
+        ctx,
+        bson.M{"_id": objectID, "archived": bson.M{"$ne": true}},
+        bson.M{
+            "$set":         bson.M{"mileage": mileAge},
+            "$currentDate": bson.M{"updated_at": true},
+        },
+        options.FindOneAndUpdate().SetReturnDocument(options.Before),
+    ).Decode(&previous)
+    if err != nil {
+        if errors.Is(err, mongo.ErrNoDocuments) {
+            return ErrVehicleNotFound
+        }
+        return err
+    }
+    anomaly := MileageAnomaly{
+        VehicleID:       objectID,
+        ReportedMileage: mileAge,
+        CurrentMileage:  previous.Mileage,
+        ReportedStatus:  previous.VehicleStatus,
+        Reason: fmt.Sprintf(
+            "mileage reset from %.2f to %.2f: %s",
+            previous.Mileage,
+            mileAge,
+            reason,
+        ),
+        CreatedAt: time.Now(),
+    }
+    _, err = repo.anomalies.InsertOne(ctx, anomaly)
+    return err
+}
+
+// DeleteVehicle archives the vehicle with the given ID,
+// the document is kept for history and only hidden from FindVehicles
+func (repo *MongoVehicleRepository) DeleteVehicle(ctx context.Context, id string) error {
+    ctx, end := repo.startOperation(ctx, "delete_vehicle")
+    defer end()
+
+    objectID, err := vehicleObjectID(id)
+    if err != nil {
+        return err
+    }
+    now := time.Now()
+    updateResult, err := repo.collection.UpdateOne(
+        ctx,
+        bson.M{"_id": objectID, "archived": bson.M{"$ne": true}},
+        bson.M{
+            "$set": bson.M{
+                "archived":   true,
+                "deleted_at": now,
+                "updated_at": now,
+            },
+        },
+    )
+    if err != nil {
+        return err
+    }
+    if updateResult.MatchedCount == 0 {
+        return ErrVehicleNotFound
+    }
+    return nil
+}
+
+// RestoreVehicle brings an archived vehicle back,
+// it fails with ErrDuplicateLicenseNumber if the plate was re-registered in the meantime
+func (repo *MongoVehicleRepository) RestoreVehicle(ctx context.Context, id string) error {
+    ctx, end := repo.startOperation(ctx, "restore_vehicle")
+    defer end()
+
+    objectID, err := vehicleObjectID(id)
+    if err != nil {
+        return err
+    }
+    updateResult, err := repo.collection.UpdateOne(
+        ctx,
+        bson.M{"_id": objectID, "archived": true},
+        bson.M{
+            "$set":         bson.M{"archived": false},
+            "$unset":       bson.M{"deleted_at": ""},
+            "$currentDate": bson.M{"updated_at": true},
+        },
+    )
+    if err != nil {
+        if mongo.IsDuplicateKeyError(err) {
+            return ErrDuplicateLicenseNumber
+        }
+        return err
+    }
+    if updateResult.MatchedCount == 0 {
+        count, err := repo.collection.CountDocuments(ctx, bson.M{"_id": objectID})
+        if err != nil {
+            return err
+        }
+        if count == 0 {
+            return ErrVehicleNotFound
+        }
+        return ErrVehicleNotArchived
+    }
+    return nil
+}
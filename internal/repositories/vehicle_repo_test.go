@@ -2,6 +2,7 @@ package repositories
 
 import (
     "context"
+    "errors"
     "fmt"
     "log"
     "math/rand"
@@ -9,6 +10,7 @@ import (
     "testing"
 
     "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -135,6 +137,18 @@ func TestMongoVehicleRepository_FindVehicleByID(t *testing.T) {
         t.Fatal("License should be equal")
     }
 
+    var sparseVehicle models.Vehicle
+
+    err = repo.FindVehicleByID(context.Background(), vehicle.ID.Hex(), &sparseVehicle, "vehicle_name")
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if sparseVehicle.VehicleName != vehicle.VehicleName || sparseVehicle.LicenseNumber != "" {
+        t.Fatal("Only the vehicle name should be read")
+    }
+
     var dbVehicle2 models.Vehicle
 
     err = repo.FindVehicleByID(context.Background(), "6734c2a5eb0eff570b970eb1", &dbVehicle2)
@@ -281,6 +295,272 @@ func TestMongoVehicleRepository_FindVehicles(t *testing.T) {
 
 }
 
+func TestMongoVehicleRepository_FindVehiclesWithFilters(t *testing.T) {
+    client, repo, err := getVehicleRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    prefix := fmt.Sprintf("Filter %d", rand.Int())
+    statuses := []models.VehicleStatus{models.VehicleStatusActive, models.VehicleStatusRented, models.VehicleStatusSold}
+    for i, status := range statuses {
+        vehicle := getRandomVehicle()
+        vehicle.SetVehicleName(fmt.Sprintf("%s %d", prefix, i)).
+            SetVehicleStatus(status).
+            SetMileage(float64(i+1) * 1000)
+        if err := repo.CreateVehicle(context.Background(), vehicle); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    mileageMin, mileageMax := 1500.0, 3000.0
+    vehicles, err := repo.FindVehicles(
+        context.Background(), &VehicleFilter{
+            VehicleName:     prefix,
+            VehicleStatuses: []models.VehicleStatus{models.VehicleStatusRented, models.VehicleStatusSold},
+            MileageMin:      &mileageMin,
+            MileageMax:      &mileageMax,
+        },
+    )
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(vehicles) != 2 {
+        t.Fatal("Should return the rented and the sold vehicle")
+    }
+
+    vehicles, err = repo.FindVehicles(
+        context.Background(), &VehicleFilter{
+            VehicleName:      prefix,
+            ExcludedStatuses: []models.VehicleStatus{models.VehicleStatusSold},
+            LicenseNumber:    "License",
+        },
+    )
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(vehicles) != 2 {
+        t.Fatal("Should not return the sold vehicle")
+    }
+
+    vehicles, err = repo.FindVehicles(
+        context.Background(), &VehicleFilter{
+            VehicleName:      prefix + " 1",
+            VehicleNameMatch: TextMatchExact,
+        },
+    )
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(vehicles) != 1 || vehicles[0].VehicleStatus != models.VehicleStatusRented {
+        t.Fatal("Should return the vehicle with the exact name")
+    }
+
+    _, err = repo.FindVehicles(
+        context.Background(), &VehicleFilter{
+            MileageMin: &mileageMax,
+            MileageMax: &mileageMin,
+        },
+    )
+
+    if !errors.Is(err, ErrInvalidQuery) {
+        t.Fatal("Error should be ErrInvalidQuery")
+    }
+}
+
+func TestMongoVehicleRepository_FindVehiclePage(t *testing.T) {
+    client, repo, err := getVehicleRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    prefix := fmt.Sprintf("Cursor %d", rand.Int())
+    for i := 0; i < 5; i++ {
+        vehicle := getRandomVehicle()
+        // the same mileage twice, so the ID breaks the tie
+        vehicle.SetVehicleName(fmt.Sprintf("%s %d", prefix, i)).SetMileage(float64(i / 2))
+        if err := repo.CreateVehicle(context.Background(), vehicle); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    sort := []SortKey{{Field: "mileage", Descending: true}}
+    var names []string
+    cursor := ""
+    for {
+        page, err := repo.FindVehiclePage(
+            context.Background(), &VehicleFilter{
+                PageSize:    2,
+                Cursor:      cursor,
+                Sort:        sort,
+                VehicleName: prefix,
+            },
+        )
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, vehicle := range page.Vehicles {
+            names = append(names, vehicle.VehicleName)
+        }
+        if cursor == "" && page.PrevCursor != "" {
+            t.Fatal("First page should not have a previous page")
+        }
+        if cursor == "" && (page.Total == nil || *page.Total != 5 || *page.TotalPages() != 3) {
+            t.Fatal("Total should be the 5 vehicles on 3 pages")
+        }
+        if cursor != "" && page.Total != nil {
+            t.Fatal("Page selected by a cursor should not be counted")
+        }
+        if page.NextCursor == "" {
+            if len(page.Vehicles) != 1 {
+                t.Fatal("Last page should have the remaining vehicle")
+            }
+
+            previous, err := repo.FindVehiclePage(
+                context.Background(), &VehicleFilter{
+                    PageSize:    2,
+                    Cursor:      page.PrevCursor,
+                    Sort:        sort,
+                    VehicleName: prefix,
+                },
+            )
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(previous.Vehicles) != 2 || previous.Vehicles[1].VehicleName != names[3] {
+                t.Fatal("Previous page should be the second page")
+            }
+            break
+        }
+        cursor = page.NextCursor
+    }
+
+    if len(names) != 5 || !strings.HasSuffix(names[0], " 4") || !strings.HasSuffix(names[4], " 0") {
+        t.Fatalf("Vehicles should be listed once, by descending mileage, got %v", names)
+    }
+}
+
+func TestMongoVehicleRepository_FindVehiclePageFields(t *testing.T) {
+    client, repo, err := getVehicleRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    prefix := fmt.Sprintf("Fields %d", rand.Int())
+    for i := 0; i < 3; i++ {
+        vehicle := getRandomVehicle()
+        vehicle.SetVehicleName(fmt.Sprintf("%s %d", prefix, i)).SetMileage(float64(i + 1))
+        if err := repo.CreateVehicle(context.Background(), vehicle); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    filter := func(cursor string) *VehicleFilter {
+        return &VehicleFilter{
+            PageSize:    2,
+            Cursor:      cursor,
+            Sort:        []SortKey{{Field: "mileage", Descending: true}},
+            VehicleName: prefix,
+            Fields:      []string{"vehicle_name"},
+        }
+    }
+    page, err := repo.FindVehiclePage(context.Background(), filter(""))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if page.NextCursor == "" {
+        t.Fatal("First page should have a cursor to the next page")
+    }
+    // the sort key and _id are read for the cursor, but they aren't selected
+    for _, vehicle := range page.Vehicles {
+        if vehicle.VehicleName == "" || vehicle.Mileage != 0 || !vehicle.ID.IsZero() {
+            t.Fatalf("Vehicle should only have the selected fields, got %+v", vehicle)
+        }
+    }
+
+    next, err := repo.FindVehiclePage(context.Background(), filter(page.NextCursor))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(next.Vehicles) != 1 || !strings.HasSuffix(next.Vehicles[0].VehicleName, " 0") {
+        t.Fatal("Next page should have the vehicle with the lowest mileage")
+    }
+}
+
+func TestMongoVehicleRepository_SearchVehicles(t *testing.T) {
+    client, repo, err := getVehicleRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    plate := fmt.Sprintf("%dB-%d", rand.Intn(10), rand.Int())
+    vehicle := getRandomVehicle()
+    vehicle.SetVehicleModel(fmt.Sprintf("Searchable%d", rand.Int())).SetLicenseNumber(plate)
+    if err := repo.CreateVehicle(context.Background(), vehicle); err != nil {
+        t.Fatal(err)
+    }
+
+    // the plate typed without its dash, in lower case and split by a space
+    searches := []string{
+        vehicle.VehicleModel,
+        licenseKey(plate),
+        strings.ToLower(strings.Replace(plate, "-", " ", 1)),
+    }
+    for _, search := range searches {
+        vehicles, err := repo.FindVehicles(
+            context.Background(), &VehicleFilter{
+                Search: search,
+            },
+        )
+
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if len(vehicles) == 0 || vehicles[0].ID != vehicle.ID {
+            t.Fatalf("Searching %s should find the vehicle first", search)
+        }
+    }
+}
+
 func TestMongoVehicleRepository_UpdateVehicleMileAge(t *testing.T) {
     client, repo, err := getVehicleRepo()
 
@@ -303,9 +583,15 @@ func TestMongoVehicleRepository_UpdateVehicleMileAge(t *testing.T) {
         t.Fatal(err)
     }
 
-    mileAge := rand.Float64() * 1000
+    mileAge := vehicle.Mileage + rand.Float64()*1000
 
-    err = repo.TrackingVehicle(context.Background(), vehicle.ID.Hex(), mileAge, models.VehicleStatusActive)
+    err = repo.TrackingVehicle(
+        context.Background(),
+        vehicle.ID.Hex(),
+        mileAge,
+        vehicle.VehicleStatus,
+        vehicle.VehicleStatus,
+    )
 
     if err != nil {
         t.Fatal(err)
@@ -323,3 +609,427 @@ func TestMongoVehicleRepository_UpdateVehicleMileAge(t *testing.T) {
         t.Fatal("Mileage should be equal")
     }
 }
+
+func TestMongoVehicleRepository_UpdateVehicle(t *testing.T) {
+    client, repo, err := getVehicleRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    vehicle := getRandomVehicle()
+
+    err = repo.CreateVehicle(context.Background(), vehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    vehicle.SetVehicleName(fmt.Sprintf("Updated %d", rand.Int()))
+
+    err = repo.UpdateVehicle(context.Background(), vehicle, vehicle.VehicleStatus)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var dbVehicle models.Vehicle
+
+    err = repo.FindVehicleByID(context.Background(), vehicle.ID.Hex(), &dbVehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if dbVehicle.VehicleName != vehicle.VehicleName {
+        t.Fatal("Vehicle name should be updated")
+    }
+
+    other := getRandomVehicle()
+
+    err = repo.CreateVehicle(context.Background(), other)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    other.SetLicenseNumber(vehicle.LicenseNumber)
+
+    err = repo.UpdateVehicle(context.Background(), other, other.VehicleStatus)
+
+    if !errors.Is(err, ErrDuplicateLicenseNumber) {
+        t.Fatal("Error should be ErrDuplicateLicenseNumber")
+    }
+}
+
+func TestMongoVehicleRepository_DeleteVehicle(t *testing.T) {
+    client, repo, err := getVehicleRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    vehicle := getRandomVehicle()
+
+    err = repo.CreateVehicle(context.Background(), vehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = repo.DeleteVehicle(context.Background(), vehicle.ID.Hex())
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = repo.DeleteVehicle(context.Background(), vehicle.ID.Hex())
+
+    if !errors.Is(err, ErrVehicleNotFound) {
+        t.Fatal("Error should be ErrVehicleNotFound")
+    }
+}
+
+func TestMongoVehicleRepository_RestoreVehicle(t *testing.T) {
+    client, repo, err := getVehicleRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    vehicle := getRandomVehicle()
+
+    err = repo.CreateVehicle(context.Background(), vehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = repo.DeleteVehicle(context.Background(), vehicle.ID.Hex())
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    vehicles, err := repo.FindVehicles(
+        context.Background(), &VehicleFilter{
+            LicenseNumber: vehicle.LicenseNumber,
+        },
+    )
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(vehicles) != 0 {
+        t.Fatal("Archived vehicle should be hidden")
+    }
+
+    vehicles, err = repo.FindVehicles(
+        context.Background(), &VehicleFilter{
+            LicenseNumber:   vehicle.LicenseNumber,
+            IncludeArchived: true,
+        },
+    )
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(vehicles) != 1 || vehicles[0].DeletedAt == nil {
+        t.Fatal("Archived vehicle should be returned with deleted_at")
+    }
+
+    // the plate can be registered again once the previous vehicle is archived
+    reRegistered := getRandomVehicle()
+    reRegistered.SetLicenseNumber(vehicle.LicenseNumber)
+
+    err = repo.CreateVehicle(context.Background(), reRegistered)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = repo.RestoreVehicle(context.Background(), vehicle.ID.Hex())
+
+    if !errors.Is(err, ErrDuplicateLicenseNumber) {
+        t.Fatal("Error should be ErrDuplicateLicenseNumber")
+    }
+
+    err = repo.RestoreVehicle(context.Background(), reRegistered.ID.Hex())
+
+    if !errors.Is(err, ErrVehicleNotArchived) {
+        t.Fatal("Error should be ErrVehicleNotArchived")
+    }
+}
+
+func TestMongoVehicleRepository_TrackingVehicleMileageDecreased(t *testing.T) {
+    client, repo, err := getVehicleRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    repo.SetMileageTolerance(5)
+
+    vehicle := getRandomVehicle()
+    vehicle.SetMileage(1000).SetVehicleStatus(models.VehicleStatusActive)
+
+    err = repo.CreateVehicle(context.Background(), vehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // within the tolerance, the status is applied but the mileage is kept
+    err = repo.TrackingVehicle(
+        context.Background(),
+        vehicle.ID.Hex(),
+        997,
+        models.VehicleStatusRepair,
+        models.VehicleStatusActive,
+    )
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var dbVehicle models.Vehicle
+
+    err = repo.FindVehicleByID(context.Background(), vehicle.ID.Hex(), &dbVehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if dbVehicle.Mileage != 1000 || dbVehicle.VehicleStatus != models.VehicleStatusRepair {
+        t.Fatal("Mileage should be kept and status should be updated")
+    }
+
+    err = repo.TrackingVehicle(
+        context.Background(),
+        vehicle.ID.Hex(),
+        900,
+        models.VehicleStatusActive,
+        models.VehicleStatusRepair,
+    )
+
+    if !errors.Is(err, ErrMileageDecreased) {
+        t.Fatal("Error should be ErrMileageDecreased")
+    }
+
+    count, err := repo.anomalies.CountDocuments(context.Background(), bson.M{"vehicle_id": vehicle.ID})
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if count != 1 {
+        t.Fatal("Rejected reading should be recorded as an anomaly")
+    }
+}
+
+func TestMongoVehicleRepository_UpdateVehicleMileageDecreased(t *testing.T) {
+    client, repo, err := getVehicleRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    vehicle := getRandomVehicle()
+
+    err = repo.CreateVehicle(context.Background(), vehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // a reading was applied after the vehicle was read for the update
+    mileAge := vehicle.Mileage + 100
+    err = repo.TrackingVehicle(
+        context.Background(),
+        vehicle.ID.Hex(),
+        mileAge,
+        vehicle.VehicleStatus,
+        vehicle.VehicleStatus,
+    )
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = repo.UpdateVehicle(context.Background(), vehicle, vehicle.VehicleStatus)
+
+    if !errors.Is(err, ErrMileageDecreased) {
+        t.Fatal("Error should be ErrMileageDecreased")
+    }
+
+    var dbVehicle models.Vehicle
+
+    err = repo.FindVehicleByID(context.Background(), vehicle.ID.Hex(), &dbVehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if dbVehicle.Mileage != mileAge {
+        t.Fatal("Mileage should be kept")
+    }
+}
+
+func TestMongoVehicleRepository_TrackingVehicleStatusChanged(t *testing.T) {
+    client, repo, err := getVehicleRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    vehicle := getRandomVehicle()
+    vehicle.SetVehicleStatus(models.VehicleStatusRented)
+
+    err = repo.CreateVehicle(context.Background(), vehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // the transition was checked while the vehicle was active, but it is rented by now
+    err = repo.TrackingVehicle(
+        context.Background(),
+        vehicle.ID.Hex(),
+        vehicle.Mileage+10,
+        models.VehicleStatusRepair,
+        models.VehicleStatusActive,
+    )
+
+    if !errors.Is(err, ErrVehicleStatusChanged) {
+        t.Fatal("Error should be ErrVehicleStatusChanged")
+    }
+
+    vehicle.SetVehicleStatus(models.VehicleStatusInactive)
+
+    err = repo.UpdateVehicle(context.Background(), vehicle, models.VehicleStatusActive)
+
+    if !errors.Is(err, ErrVehicleStatusChanged) {
+        t.Fatal("Error should be ErrVehicleStatusChanged")
+    }
+
+    var dbVehicle models.Vehicle
+
+    err = repo.FindVehicleByID(context.Background(), vehicle.ID.Hex(), &dbVehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if dbVehicle.VehicleStatus != models.VehicleStatusRented {
+        t.Fatal("Status should be kept")
+    }
+}
+
+func TestMongoVehicleRepository_ResetMileage(t *testing.T) {
+    client, repo, err := getVehicleRepo()
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    defer func(client *mongo.Client, ctx context.Context) {
+        err := client.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database")
+        }
+    }(client, context.Background())
+
+    vehicle := getRandomVehicle()
+    vehicle.SetMileage(999990)
+
+    err = repo.CreateVehicle(context.Background(), vehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // the odometer was replaced, its readings start again from 0
+    err = repo.ResetMileage(context.Background(), vehicle.ID.Hex(), 0, "odometer replaced")
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = repo.TrackingVehicle(
+        context.Background(),
+        vehicle.ID.Hex(),
+        12,
+        vehicle.VehicleStatus,
+        vehicle.VehicleStatus,
+    )
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var dbVehicle models.Vehicle
+
+    err = repo.FindVehicleByID(context.Background(), vehicle.ID.Hex(), &dbVehicle)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if dbVehicle.Mileage != 12 {
+        t.Fatal("Readings of the new odometer should be applied")
+    }
+
+    count, err := repo.anomalies.CountDocuments(context.Background(), bson.M{"vehicle_id": vehicle.ID})
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if count != 1 {
+        t.Fatal("Reset should be recorded as an anomaly")
+    }
+}
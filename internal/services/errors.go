@@ -0,0 +1,70 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+)
+
+// modelFieldErrors maps the validation errors of the models to the field (by its JSON name) and the rule they break
+var modelFieldErrors = map[error]repositories.FieldError{
+    models.ErrVehicleNameEmpty:     {Field: "vehicle_name", Rule: "required"},
+    models.ErrVehicleModelEmpty:    {Field: "vehicle_model", Rule: "required"},
+    models.ErrVehicleStatusEmpty:   {Field: "vehicle_status", Rule: "required"},
+    models.ErrInvalidVehicleStatus: {Field: "vehicle_status", Rule: "oneof"},
+    models.ErrLicenseNumberEmpty:   {Field: "license_number", Rule: "required"},
+    models.ErrVehicleIDEmpty:       {Field: "vehicle_id", Rule: "required"},
+    models.ErrInvalidVehicleID:     {Field: "vehicle_id", Rule: "objectid"},
+    models.ErrLocationEmpty:        {Field: "location", Rule: "required"},
+    models.ErrMileageEmpty:         {Field: "mileage", Rule: "required"},
+    models.ErrFuelConditionEmpty:   {Field: "fuel_condition", Rule: "required"},
+    models.ErrInvalidFuelCondition: {Field: "fuel_condition", Rule: "oneof"},
+}
+
+// validationError turns the validation errors of the models into a ValidationError of the invalid field,
+// the other errors are returned as they are
+func validationError(err error) error {
+    for modelErr, field := range modelFieldErrors {
+        if errors.Is(err, modelErr) {
+            field.Message = modelErr.Error()
+            return repositories.NewValidationError(err, field)
+        }
+    }
+    return err
+}
+
+// conflictingQuery is returned when two query parameters set the same filter, e.g. mileage and mileage_min,
+// the parameters are named in alphabetical order so the error doesn't depend on the order they were parsed in
+func conflictingQuery(param, other string) error {
+    first, second := min(param, other), max(param, other)
+    message := fmt.Sprintf("%s can't be combined with %s", first, second)
+    return repositories.NewValidationError(
+        fmt.Errorf("%w: %s", repositories.ErrInvalidQuery, message),
+        repositories.FieldError{Field: second, Rule: "conflict", Message: message},
+    )
+}
+
+// invalidQuery is returned when a query parameter can't be parsed, e.g. invalidQuery("page", "number")
+func invalidQuery(param, rule string) error {
+    message := fmt.Sprintf("%s must be a valid %s", param, rule)
+    return repositories.NewValidationError(
+        fmt.Errorf("%w: %s", repositories.ErrInvalidQuery, message),
+        repositories.FieldError{Field: param, Rule: rule, Message: message},
+    )
+}
+
+// mileageDecreased is returned when an update would lower the vehicle's mileage,
+// the mileage only goes down by a mileage reset, which records the reason with the mileage anomalies
+func mileageDecreased(id string, current float64) error {
+    message := fmt.Sprintf(
+        "mileage must not be less than the current mileage %g, use POST /api/v1/vehicles/%s/mileage-reset to lower it",
+        current,
+        id,
+    )
+    return repositories.NewValidationError(
+        errors.New(message),
+        repositories.FieldError{Field: "mileage", Rule: "gte", Message: message},
+    )
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+)
+
+// textFilters are the query parameters which are matched as text,
+// an operator in brackets picks the match, e.g. vehicle_name[contains]=oro or license_number[not_exact]=ABC-123
+var textFilters = map[string]func(filter *repositories.VehicleFilter, value string, match repositories.TextMatch){
+    "vehicle_name": func(filter *repositories.VehicleFilter, value string, match repositories.TextMatch) {
+        filter.VehicleName, filter.VehicleNameMatch = value, match
+    },
+    "vehicle_model": func(filter *repositories.VehicleFilter, value string, match repositories.TextMatch) {
+        filter.VehicleModel, filter.VehicleModelMatch = value, match
+    },
+    "license_number": func(filter *repositories.VehicleFilter, value string, match repositories.TextMatch) {
+        filter.LicenseNumber, filter.LicenseNumberMatch = value, match
+    },
+}
+
+// filterTargets are the query parameters which set the same filter under another name or operator,
+// the text filters are their own target whatever their operator is
+var filterTargets = map[string]string{
+    "mileage": "mileage_min",
+}
+
+// ParseVehicleFilter parses the query parameters of the vehicle list,
+// unsupported parameters are ignored and a value which can't be parsed, an unsupported operator
+// or two parameters which set the same filter (e.g. vehicle_name and vehicle_name[contains]) is an invalid query error
+func ParseVehicleFilter(query url.Values) (*repositories.VehicleFilter, error) {
+    filter := &repositories.VehicleFilter{}
+    var err error
+    // targets are the filters which were set, by the parameter which set them
+    targets := map[string]string{}
+
+    for key, values := range query {
+        value := values[0]
+        name, operator, hasOperator := strings.Cut(key, "[")
+        if hasOperator {
+            if !strings.HasSuffix(operator, "]") {
+                return nil, invalidQuery(key, "operator")
+            }
+            operator = strings.TrimSuffix(operator, "]")
+        }
+
+        target := name
+        if alias, ok := filterTargets[name]; ok {
+            target = alias
+        }
+        if name == "vehicle_status" && hasOperator {
+            target = key
+        }
+        if other, ok := targets[target]; ok {
+            return nil, conflictingQuery(key, other)
+        }
+        targets[target] = key
+
+        if setText, ok := textFilters[name]; ok {
+            match := repositories.TextMatch(operator)
+            if err := match.Valid(); err != nil {
+                return nil, invalidQuery(key, "operator")
+            }
+            setText(filter, value, match)
+            continue
+        }
+        // only the text filters and vehicle_status[not] take an operator
+        if hasOperator && (name != "vehicle_status" || operator != "not") {
+            return nil, invalidQuery(key, "operator")
+        }
+
+        switch name {
+        case "page":
+            if filter.Page, err = strconv.Atoi(value); err != nil {
+                return nil, invalidQuery(key, "integer")
+            }
+        case "limit":
+            if filter.PageSize, err = strconv.Atoi(value); err != nil {
+                return nil, invalidQuery(key, "integer")
+            }
+        case "q":
+            filter.Search = strings.TrimSpace(value)
+        case "fields":
+            filter.Fields = ParseFields(value)
+        case "cursor":
+            filter.Cursor = value
+        case "sort":
+            if filter.Sort, err = parseSort(value); err != nil {
+                return nil, err
+            }
+        case "sort_by":
+            filter.SortField = value
+        case "sort_order":
+            filter.SortOrder = value
+        case "_id":
+            filter.ID = value
+        case "include_archived":
+            if filter.IncludeArchived, err = strconv.ParseBool(value); err != nil {
+                return nil, invalidQuery(key, "boolean")
+            }
+        case "vehicle_status":
+            statuses := parseStatuses(value)
+            if hasOperator {
+                filter.ExcludedStatuses = statuses
+            } else {
+                filter.VehicleStatuses = statuses
+            }
+        // mileage is the lower bound, like it was before the ranges were supported
+        case "mileage", "mileage_min":
+            if filter.MileageMin, err = parseMileage(key, value); err != nil {
+                return nil, err
+            }
+        case "mileage_max":
+            if filter.MileageMax, err = parseMileage(key, value); err != nil {
+                return nil, err
+            }
+        case "created_from":
+            if filter.CreatedFrom, err = parseTimestamp(key, value); err != nil {
+                return nil, err
+            }
+        case "created_to":
+            if filter.CreatedTo, err = parseTimestamp(key, value); err != nil {
+                return nil, err
+            }
+        case "updated_since":
+            if filter.UpdatedSince, err = parseTimestamp(key, value); err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    return filter, nil
+}
+
+// ParseFields splits the comma separated fields of the fields query parameter, e.g. id,vehicle_name,vehicle_status,
+// the repository checks they are vehicle fields
+func ParseFields(value string) []string {
+    var fields []string
+    for _, field := range strings.Split(value, ",") {
+        if field = strings.TrimSpace(field); field != "" {
+            fields = append(fields, field)
+        }
+    }
+    return fields
+}
+
+// parseStatuses splits a comma separated list of statuses, e.g. active,rented
+func parseStatuses(value string) []models.VehicleStatus {
+    var statuses []models.VehicleStatus
+    for _, status := range strings.Split(value, ",") {
+        if status = strings.TrimSpace(status); status != "" {
+            statuses = append(statuses, models.VehicleStatus(status))
+        }
+    }
+    return statuses
+}
+
+// parseSort parses a multi-key sort, the fields are comma separated and a leading - sorts the field descending,
+// e.g. -mileage,created_at. The repository checks the fields are sortable
+func parseSort(value string) ([]repositories.SortKey, error) {
+    var keys []repositories.SortKey
+    for _, field := range strings.Split(value, ",") {
+        field = strings.TrimSpace(field)
+        descending := strings.HasPrefix(field, "-")
+        field = strings.TrimLeft(field, "+-")
+        if field == "" {
+            return nil, invalidQuery("sort", "list of fields")
+        }
+        keys = append(keys, repositories.SortKey{Field: field, Descending: descending})
+    }
+    return keys, nil
+}
+
+func parseMileage(param, value string) (*float64, error) {
+    mileage, err := strconv.ParseFloat(value, 64)
+    if err != nil {
+        return nil, invalidQuery(param, "number")
+    }
+    if mileage < 0 {
+        return nil, invalidQuery(param, "non-negative number")
+    }
+    return &mileage, nil
+}
+
+func parseTimestamp(param, value string) (time.Time, error) {
+    timestamp, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        return time.Time{}, invalidQuery(param, "RFC 3339 timestamp")
+    }
+    return timestamp, nil
+}
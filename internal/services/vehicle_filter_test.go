@@ -0,0 +1,87 @@
+package services
+
+import (
+    "errors"
+    "net/url"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+)
+
+func TestParseVehicleFilter(t *testing.T) {
+    query, err := url.ParseQuery(
+        "vehicle_name[contains]=oro&license_number[not_exact]=ABC-123&vehicle_model=Cor" +
+            "&vehicle_status=active,rented&vehicle_status[not]=sold" +
+            "&mileage_min=100&mileage_max=2000.5&created_from=2024-01-01T00:00:00Z" +
+            "&updated_since=2024-06-01T00:00:00Z&page=2&limit=20&unknown=1" +
+            "&sort=-vehicle_status,-created_at&q=+corolla+4B+",
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    filter, err := ParseVehicleFilter(query)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    mileageMin, mileageMax := 100.0, 2000.5
+    want := &repositories.VehicleFilter{
+        Page:               2,
+        PageSize:           20,
+        VehicleName:        "oro",
+        VehicleNameMatch:   repositories.TextMatchContains,
+        VehicleModel:       "Cor",
+        LicenseNumber:      "ABC-123",
+        LicenseNumberMatch: repositories.TextMatchNotExact,
+        VehicleStatuses:    []models.VehicleStatus{models.VehicleStatusActive, models.VehicleStatusRented},
+        ExcludedStatuses:   []models.VehicleStatus{models.VehicleStatusSold},
+        MileageMin:         &mileageMin,
+        MileageMax:         &mileageMax,
+        CreatedFrom:        time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+        UpdatedSince:       time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+        Search:             "corolla 4B",
+        Sort: []repositories.SortKey{
+            {Field: "vehicle_status", Descending: true},
+            {Field: "created_at", Descending: true},
+        },
+    }
+    if !reflect.DeepEqual(filter, want) {
+        t.Fatalf("Filter should be %+v, got %+v", want, filter)
+    }
+}
+
+func TestParseVehicleFilter_Invalid(t *testing.T) {
+    tests := []string{
+        "page=one",
+        "mileage_min=-1",
+        "mileage_max=far",
+        "created_from=yesterday",
+        "updated_since=2024-06-01",
+        "include_archived=maybe",
+        "vehicle_name[fuzzy]=oro",
+        "vehicle_name[contains=oro",
+        "sort=-mileage,,created_at",
+        "sort=-",
+        "vehicle_status[in]=active",
+        "mileage[gte]=100",
+        "vehicle_name=Cor&vehicle_name[contains]=oro",
+        "mileage=100&mileage_min=200",
+    }
+
+    for _, rawQuery := range tests {
+        query, err := url.ParseQuery(rawQuery)
+        if err != nil {
+            t.Fatal(err)
+        }
+        _, err = ParseVehicleFilter(query)
+
+        var validationErr *repositories.ValidationError
+        if !errors.Is(err, repositories.ErrInvalidQuery) || !errors.As(err, &validationErr) {
+            t.Fatalf("%s should be an invalid query, got %v", rawQuery, err)
+        }
+    }
+}
@@ -2,12 +2,44 @@ package services
 
 import (
     "context"
+    "errors"
+    "fmt"
+    "log/slog"
     "net/url"
     "strconv"
 
     "github.com/goccy/go-json"
     "github.com/yemyoaung/managing-vehicle-tracking-models"
     "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+    ErrInvalidPatch = repositories.NewError(
+        repositories.KindInvalidArgument,
+        "invalid_patch",
+        "invalid merge patch, expected a JSON object",
+    )
+    ErrVehicleArchived = repositories.NewError(
+        repositories.KindConflict,
+        "vehicle_archived",
+        "vehicle is archived, restore it before updating",
+    )
+    ErrInvalidTimeRange = repositories.NewError(
+        repositories.KindInvalidArgument,
+        "invalid_time_range",
+        "invalid time range, from must be before to",
+    )
+    ErrDuplicateMessage = repositories.NewError(
+        repositories.KindConflict,
+        "duplicate_message",
+        "tracking message was already processed",
+    )
+    ErrTrackingNotQueued = repositories.NewError(
+        repositories.KindUnavailable,
+        "tracking_not_queued",
+        "tracking data could not be queued for publishing",
+    )
 )
 
 type VehicleRequest struct {
@@ -25,32 +57,114 @@ func (v *VehicleRequest) Validate() error {
     return nil
 }
 
+// MileageResetRequest starts a new mileage baseline, e.g. after the odometer was replaced or rolled over
+type MileageResetRequest struct {
+    Mileage float64 `json:"mileage" validate:"gte=0"`
+    Reason  string  `json:"reason" validate:"required"`
+}
+
+// NewVehicleRequest creates a VehicleRequest from the current state of the vehicle
+func NewVehicleRequest(vehicle *models.Vehicle) *VehicleRequest {
+    return &VehicleRequest{
+        VehicleName:   vehicle.VehicleName,
+        VehicleModel:  vehicle.VehicleModel,
+        VehicleStatus: vehicle.VehicleStatus,
+        Mileage:       vehicle.Mileage,
+        LicenseNumber: vehicle.LicenseNumber,
+    }
+}
+
+// MergeVehiclePatch applies a JSON merge patch (RFC 7386) on top of the current vehicle
+// and returns the resulting request, the caller is responsible for validating it
+func MergeVehiclePatch(vehicle *models.Vehicle, patch []byte) (*VehicleRequest, error) {
+    var patchDoc map[string]any
+    if err := json.Unmarshal(patch, &patchDoc); err != nil || patchDoc == nil {
+        return nil, ErrInvalidPatch
+    }
+
+    buf, err := json.Marshal(NewVehicleRequest(vehicle))
+    if err != nil {
+        return nil, err
+    }
+    var target map[string]any
+    if err := json.Unmarshal(buf, &target); err != nil {
+        return nil, err
+    }
+
+    mergePatch(target, patchDoc)
+
+    buf, err = json.Marshal(target)
+    if err != nil {
+        return nil, err
+    }
+    var req VehicleRequest
+    if err := json.Unmarshal(buf, &req); err != nil {
+        return nil, err
+    }
+    return &req, nil
+}
+
+// mergePatch merges the patch into the target in place,
+// null values remove the key and nested objects are merged recursively
+func mergePatch(target map[string]any, patch map[string]any) {
+    for key, value := range patch {
+        if value == nil {
+            delete(target, key)
+            continue
+        }
+        if patchObject, ok := value.(map[string]any); ok {
+            targetObject, ok := target[key].(map[string]any)
+            if !ok {
+                targetObject = map[string]any{}
+            }
+            mergePatch(targetObject, patchObject)
+            target[key] = targetObject
+            continue
+        }
+        target[key] = value
+    }
+}
+
 type VehicleService interface {
     CreateVehicle(ctx context.Context, req *VehicleRequest) (*models.Vehicle, error)
-    TrackingVehicle(ctx context.Context, id string, mileAge float64, status models.VehicleStatus) error
-    FindVehicles(ctx context.Context, query url.Values) ([]*models.Vehicle, error)
-    GetVehicleByID(ctx context.Context, id string) (*models.Vehicle, error)
-    PublishTrackingData(ctx context.Context, req *models.TrackingDataRequest) error
+    TrackingVehicle(ctx context.Context, messageID string, req *models.TrackingDataRequest) error
+    FindVehicles(ctx context.Context, query url.Values) (*repositories.VehiclePage, error)
+    GetVehicleByID(ctx context.Context, id string, fields ...string) (*models.Vehicle, error)
+    PublishTrackingData(ctx context.Context, messageID string, req *models.TrackingDataRequest) (string, error)
+    UpdateVehicle(ctx context.Context, id string, req *VehicleRequest) (*models.Vehicle, error)
+    ResetMileage(ctx context.Context, id string, req *MileageResetRequest) (*models.Vehicle, error)
+    DeleteVehicle(ctx context.Context, id string) error
+    RestoreVehicle(ctx context.Context, id string) (*models.Vehicle, error)
+    FindTrackingHistory(ctx context.Context, id string, query url.Values) ([]*models.TrackingData, error)
 }
 
 type MongoVehicleService struct {
-    vehicleRepo  repositories.VehicleRepository
-    trackingRepo repositories.TrackingRepository
+    vehicleRepo   repositories.VehicleRepository
+    outboxRepo    repositories.OutboxRepository
+    historyRepo   repositories.TrackingHistoryRepository
+    processedRepo repositories.ProcessedMessageRepository
 }
 
 func NewMongoVehicleService(
     vehicleRepo repositories.VehicleRepository,
-    trackingRepo repositories.TrackingRepository,
+    outboxRepo repositories.OutboxRepository,
+    historyRepo repositories.TrackingHistoryRepository,
+    processedRepo repositories.ProcessedMessageRepository,
 ) *MongoVehicleService {
     return &MongoVehicleService{
-        vehicleRepo:  vehicleRepo,
-        trackingRepo: trackingRepo,
+        vehicleRepo:   vehicleRepo,
+        outboxRepo:    outboxRepo,
+        historyRepo:   historyRepo,
+        processedRepo: processedRepo,
     }
 }
 
 func (s *MongoVehicleService) CreateVehicle(ctx context.Context, req *VehicleRequest) (*models.Vehicle, error) {
     if err := req.Validate(); err != nil {
-        return nil, err
+        return nil, validationError(err)
+    }
+    if err := CheckInitialStatus(req.VehicleStatus); err != nil {
+        return nil, validationError(err)
     }
     vehicle := models.NewVehicle().
         SetVehicleName(req.VehicleName).
@@ -60,79 +174,255 @@ func (s *MongoVehicleService) CreateVehicle(ctx context.Context, req *VehicleReq
         SetLicenseNumber(req.LicenseNumber)
     err := s.vehicleRepo.CreateVehicle(ctx, vehicle)
     if err != nil {
-        return nil, err
+        return nil, validationError(err)
     }
     return vehicle, nil
 }
 
+// TrackingVehicle applies the latest mileage and status to the vehicle
+// and keeps the whole data point, including location and fuel condition, in the tracking history,
+// a message which was already applied returns ErrDuplicateMessage (messages without an ID are always applied).
+// A reading which is rejected (a decreasing mileage or a forbidden status transition) is still kept in the history,
+// only the vehicle isn't updated, and the rejection is returned.
+// The message ID is claimed before anything is applied and released again when applying it failed,
+// so a redelivered copy is never applied at the same time
 func (s *MongoVehicleService) TrackingVehicle(
     ctx context.Context,
-    id string,
-    mileAge float64,
-    status models.VehicleStatus,
+    messageID string,
+    req *models.TrackingDataRequest,
 ) error {
-    return s.vehicleRepo.TrackingVehicle(ctx, id, mileAge, status)
-}
-
-func (s *MongoVehicleService) FindVehicles(ctx context.Context, query url.Values) ([]*models.Vehicle, error) {
-    // by converting url.Values to map[string]any and unmarshalling it to VehicleFilter,
-    // we can ignore unsupported query parameters
-    data := map[string]any{}
-    for key, value := range query {
-        if key == "page" || key == "limit" {
-            converted, err := strconv.Atoi(value[0])
-            if err != nil {
-                return nil, err
-            }
-            data[key] = converted
-            continue
-        }
-        if key == "mileage" {
-            converted, err := strconv.ParseFloat(value[0], 64)
-            if err != nil {
-                return nil, err
-            }
-            data[key] = converted
-            continue
+    trackingData, err := req.ToTrackingData()
+    if err != nil {
+        return validationError(err)
+    }
+    if messageID == "" {
+        return s.applyTrackingData(ctx, req, trackingData)
+    }
+
+    claimed, err := s.processedRepo.Claim(ctx, messageID)
+    if err != nil {
+        return err
+    }
+    if !claimed {
+        return ErrDuplicateMessage
+    }
+    err = s.applyTrackingData(ctx, req, trackingData)
+    if err != nil && !isRejectedReading(err) {
+        // the retry or the redelivery has to be able to claim it again
+        if releaseErr := s.processedRepo.Release(ctx, messageID); releaseErr != nil {
+            slog.WarnContext(ctx, "Failed to release tracking message", slog.Any("error", releaseErr))
         }
-        data[key] = value[0]
+        return err
     }
+    rejected := err
+    // a rejected reading is marked as well, it was recorded and would be rejected again
+    if err := s.processedRepo.MarkProcessed(ctx, messageID); err != nil {
+        return err
+    }
+    return rejected
+}
 
-    buf, err := json.Marshal(data)
+// applyTrackingData updates the vehicle and saves the data point in the history,
+// the data point is saved as well when the reading is rejected and the rejection is returned after it
+func (s *MongoVehicleService) applyTrackingData(
+    ctx context.Context,
+    req *models.TrackingDataRequest,
+    trackingData *models.TrackingData,
+) error {
+    vehicle, err := s.GetVehicleByID(ctx, req.VehicleID)
     if err != nil {
-        return nil, err
+        return err
+    }
+    err = CheckStatusTransition(vehicle.VehicleStatus, req.Status)
+    if err == nil {
+        err = s.vehicleRepo.TrackingVehicle(ctx, req.VehicleID, req.Mileage, req.Status, vehicle.VehicleStatus)
+    }
+    if err != nil && !isRejectedReading(err) {
+        return err
     }
+    rejected := err
+    if err := s.historyRepo.SaveTrackingData(ctx, trackingData); err != nil {
+        return err
+    }
+    if rejected != nil {
+        return rejected
+    }
+    slog.InfoContext(
+        ctx,
+        "Applied tracking data",
+        slog.String("vehicle_id", req.VehicleID),
+        slog.Float64("mileage", req.Mileage),
+        slog.String("status", string(req.Status)),
+    )
+    return nil
+}
+
+// isRejectedReading reports whether the tracking reading was rejected for good,
+// processing it again would be rejected the same way
+func isRejectedReading(err error) bool {
+    return errors.Is(err, repositories.ErrMileageDecreased) || errors.Is(err, ErrInvalidStatusTransition)
+}
 
-    var filter repositories.VehicleFilter
-    if err := json.Unmarshal(buf, &filter); err != nil {
+// FindVehicles returns the page of the vehicles which match the query,
+// the page is selected by the cursor query parameter or by page and limit
+func (s *MongoVehicleService) FindVehicles(ctx context.Context, query url.Values) (*repositories.VehiclePage, error) {
+    filter, err := ParseVehicleFilter(query)
+    if err != nil {
         return nil, err
     }
 
-    return s.vehicleRepo.FindVehicles(ctx, &filter)
+    return s.vehicleRepo.FindVehiclePage(ctx, filter)
 }
 
-func (s *MongoVehicleService) GetVehicleByID(ctx context.Context, id string) (
+// GetVehicleByID returns the vehicle, only the given fields are read when there are any
+func (s *MongoVehicleService) GetVehicleByID(ctx context.Context, id string, fields ...string) (
     *models.Vehicle,
     error,
 ) {
     var vehicle models.Vehicle
-    err := s.vehicleRepo.FindVehicleByID(ctx, id, &vehicle)
+    err := s.vehicleRepo.FindVehicleByID(ctx, id, &vehicle, fields...)
     if err != nil {
         return nil, err
     }
     return &vehicle, nil
 }
 
+// PublishTrackingData queues the tracking data in the outbox with the given message ID and returns it,
+// the outbox relay publishes it once the broker is available.
+// The ID is generated when it is empty, publishing again with the same ID is applied only once
 func (s *MongoVehicleService) PublishTrackingData(
     ctx context.Context,
+    messageID string,
     req *models.TrackingDataRequest,
-) error {
+) (string, error) {
     if err := req.Validate(); err != nil {
-        return err
+        return "", validationError(err)
     }
     buf, err := json.Marshal(req)
     if err != nil {
-        return err
+        return "", err
+    }
+    if messageID == "" {
+        messageID = primitive.NewObjectID().Hex()
     }
-    return s.trackingRepo.PublishTrackingData(ctx, buf)
+    if err := s.outboxRepo.AddMessage(ctx, messageID, buf); err != nil {
+        return "", fmt.Errorf("%w: %w", ErrTrackingNotQueued, err)
+    }
+    // the request ID of ctx is queued with the message as its correlation ID
+    slog.InfoContext(
+        ctx,
+        "Queued tracking data",
+        slog.String("message_id", messageID),
+        slog.String("vehicle_id", req.VehicleID),
+    )
+    return messageID, nil
+}
+
+// UpdateVehicle replaces the vehicle's editable fields with the given request,
+// the mileage can't be lowered, that is what ResetMileage is for
+func (s *MongoVehicleService) UpdateVehicle(
+    ctx context.Context,
+    id string,
+    req *VehicleRequest,
+) (*models.Vehicle, error) {
+    if err := req.Validate(); err != nil {
+        return nil, validationError(err)
+    }
+    vehicle, err := s.GetVehicleByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if vehicle.DeletedAt != nil {
+        return nil, ErrVehicleArchived
+    }
+    currentStatus := vehicle.VehicleStatus
+    if err := CheckStatusTransition(currentStatus, req.VehicleStatus); err != nil {
+        return nil, validationError(err)
+    }
+    if req.Mileage < vehicle.Mileage {
+        return nil, mileageDecreased(id, vehicle.Mileage)
+    }
+    vehicle.SetVehicleName(req.VehicleName).
+        SetVehicleModel(req.VehicleModel).
+        SetVehicleStatus(req.VehicleStatus).
+        SetMileage(req.Mileage).
+        SetLicenseNumber(req.LicenseNumber)
+    if err := s.vehicleRepo.UpdateVehicle(ctx, vehicle, currentStatus); err != nil {
+        return nil, validationError(err)
+    }
+    return vehicle, nil
+}
+
+// ResetMileage replaces the vehicle's mileage even if it is lower, the tracking readings are checked against
+// the new mileage from then on and the reset is kept with the mileage anomalies
+func (s *MongoVehicleService) ResetMileage(
+    ctx context.Context,
+    id string,
+    req *MileageResetRequest,
+) (*models.Vehicle, error) {
+    if err := s.vehicleRepo.ResetMileage(ctx, id, req.Mileage, req.Reason); err != nil {
+        return nil, err
+    }
+    slog.InfoContext(
+        ctx,
+        "Reset vehicle mileage",
+        slog.String("vehicle_id", id),
+        slog.Float64("mileage", req.Mileage),
+        slog.String("reason", req.Reason),
+    )
+    return s.GetVehicleByID(ctx, id)
+}
+
+// DeleteVehicle archives the vehicle, it can be brought back with RestoreVehicle
+func (s *MongoVehicleService) DeleteVehicle(ctx context.Context, id string) error {
+    return s.vehicleRepo.DeleteVehicle(ctx, id)
+}
+
+func (s *MongoVehicleService) RestoreVehicle(ctx context.Context, id string) (*models.Vehicle, error) {
+    if err := s.vehicleRepo.RestoreVehicle(ctx, id); err != nil {
+        return nil, err
+    }
+    return s.GetVehicleByID(ctx, id)
+}
+
+// FindTrackingHistory returns the tracking data of a vehicle between the optional from and to query parameters,
+// both are RFC 3339 timestamps, and it is paginated with page and limit like FindVehicles
+func (s *MongoVehicleService) FindTrackingHistory(
+    ctx context.Context,
+    id string,
+    query url.Values,
+) ([]*models.TrackingData, error) {
+    filter := repositories.TrackingHistoryFilter{VehicleID: id}
+
+    var err error
+    if value := query.Get("from"); value != "" {
+        if filter.From, err = parseTimestamp("from", value); err != nil {
+            return nil, err
+        }
+    }
+    if value := query.Get("to"); value != "" {
+        if filter.To, err = parseTimestamp("to", value); err != nil {
+            return nil, err
+        }
+    }
+    if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+        return nil, ErrInvalidTimeRange
+    }
+    if value := query.Get("page"); value != "" {
+        if filter.Page, err = strconv.Atoi(value); err != nil {
+            return nil, invalidQuery("page", "integer")
+        }
+    }
+    if value := query.Get("limit"); value != "" {
+        if filter.PageSize, err = strconv.Atoi(value); err != nil {
+            return nil, invalidQuery("limit", "integer")
+        }
+    }
+
+    // make sure the vehicle exists, so an unknown ID is reported instead of an empty history
+    if _, err := s.GetVehicleByID(ctx, id); err != nil {
+        return nil, err
+    }
+
+    return s.historyRepo.FindTrackingHistory(ctx, &filter)
 }
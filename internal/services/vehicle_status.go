@@ -0,0 +1,108 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+)
+
+var (
+    ErrInvalidStatusTransition = repositories.NewError(
+        repositories.KindConflict,
+        "invalid_status_transition",
+        "invalid vehicle status transition",
+    )
+    // ErrInvalidInitialStatus is a new vehicle with a terminal status, it is reported as an invalid vehicle_status
+    ErrInvalidInitialStatus = errors.New("invalid initial vehicle status")
+)
+
+// vehicleStatusTransitions lists the statuses a vehicle can move to from its current status,
+// staying in the same status is always allowed because tracking messages repeat the current status
+var vehicleStatusTransitions = map[models.VehicleStatus][]models.VehicleStatus{
+    models.VehicleStatusActive: {
+        models.VehicleStatusInactive,
+        models.VehicleStatusRepair,
+        models.VehicleStatusRented,
+        models.VehicleStatusSold,
+    },
+    models.VehicleStatusInactive: {
+        models.VehicleStatusActive,
+        models.VehicleStatusRepair,
+        models.VehicleStatusSold,
+    },
+    models.VehicleStatusRepair: {
+        models.VehicleStatusActive,
+        models.VehicleStatusInactive,
+        models.VehicleStatusSold,
+    },
+    // a rented vehicle has to be returned (active) before anything else can happen to it
+    models.VehicleStatusRented: {
+        models.VehicleStatusActive,
+    },
+    // sold is terminal
+    models.VehicleStatusSold: {},
+}
+
+// StatusTransitionError is returned when a vehicle can't move from its current status to the requested one
+type StatusTransitionError struct {
+    From    models.VehicleStatus
+    To      models.VehicleStatus
+    Allowed []models.VehicleStatus
+}
+
+func (e *StatusTransitionError) Error() string {
+    if len(e.Allowed) == 0 {
+        return fmt.Sprintf("vehicle status can't change from %s, it is a terminal status", e.From)
+    }
+    allowed := make([]string, 0, len(e.Allowed))
+    for _, status := range e.Allowed {
+        allowed = append(allowed, string(status))
+    }
+    return fmt.Sprintf(
+        "vehicle status can't change from %s to %s, allowed next statuses: %s",
+        e.From,
+        e.To,
+        strings.Join(allowed, ", "),
+    )
+}
+
+func (e *StatusTransitionError) Unwrap() error {
+    return ErrInvalidStatusTransition
+}
+
+// CheckStatusTransition returns a StatusTransitionError if the vehicle can't move from one status to the other
+func CheckStatusTransition(from, to models.VehicleStatus) error {
+    if err := to.Valid(); err != nil {
+        return err
+    }
+    if from == to {
+        return nil
+    }
+    allowed := vehicleStatusTransitions[from]
+    for _, status := range allowed {
+        if status == to {
+            return nil
+        }
+    }
+    return &StatusTransitionError{From: from, To: to, Allowed: allowed}
+}
+
+// CheckInitialStatus returns a ValidationError of vehicle_status if a new vehicle can't start with the given status,
+// a vehicle can start in any status which still has a next status.
+// There is no current status a new vehicle could conflict with, so it is an invalid request and not a conflict
+func CheckInitialStatus(status models.VehicleStatus) error {
+    if err := status.Valid(); err != nil {
+        return err
+    }
+    if len(vehicleStatusTransitions[status]) == 0 {
+        message := fmt.Sprintf("vehicle can't be created with status %s, it is a terminal status", status)
+        return repositories.NewValidationError(
+            fmt.Errorf("%w: %s", ErrInvalidInitialStatus, message),
+            repositories.FieldError{Field: "vehicle_status", Rule: "initial_status", Message: message},
+        )
+    }
+    return nil
+}
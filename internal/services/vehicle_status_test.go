@@ -0,0 +1,68 @@
+package services
+
+import (
+    "errors"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+)
+
+func TestCheckStatusTransition(t *testing.T) {
+    tests := []struct {
+        from    models.VehicleStatus
+        to      models.VehicleStatus
+        allowed bool
+    }{
+        {models.VehicleStatusActive, models.VehicleStatusActive, true},
+        {models.VehicleStatusActive, models.VehicleStatusRented, true},
+        {models.VehicleStatusActive, models.VehicleStatusSold, true},
+        {models.VehicleStatusRented, models.VehicleStatusActive, true},
+        {models.VehicleStatusRented, models.VehicleStatusRepair, false},
+        {models.VehicleStatusRented, models.VehicleStatusSold, false},
+        {models.VehicleStatusRepair, models.VehicleStatusRented, false},
+        {models.VehicleStatusSold, models.VehicleStatusSold, true},
+        {models.VehicleStatusSold, models.VehicleStatusActive, false},
+    }
+
+    for _, test := range tests {
+        err := CheckStatusTransition(test.from, test.to)
+        if test.allowed && err != nil {
+            t.Fatalf("%s -> %s should be allowed: %v", test.from, test.to, err)
+        }
+        if !test.allowed && !errors.Is(err, ErrInvalidStatusTransition) {
+            t.Fatalf("%s -> %s should not be allowed", test.from, test.to)
+        }
+    }
+
+    err := CheckStatusTransition(models.VehicleStatusRented, models.VehicleStatusSold)
+
+    var transitionErr *StatusTransitionError
+    if !errors.As(err, &transitionErr) {
+        t.Fatal("Error should be StatusTransitionError")
+    }
+
+    if len(transitionErr.Allowed) != 1 || transitionErr.Allowed[0] != models.VehicleStatusActive {
+        t.Fatal("Rented vehicle should only be allowed to become active")
+    }
+
+    if !errors.Is(CheckStatusTransition(models.VehicleStatusActive, "broken"), models.ErrInvalidVehicleStatus) {
+        t.Fatal("Unknown status should be invalid")
+    }
+}
+
+func TestCheckInitialStatus(t *testing.T) {
+    if err := CheckInitialStatus(models.VehicleStatusActive); err != nil {
+        t.Fatal(err)
+    }
+
+    err := CheckInitialStatus(models.VehicleStatusSold)
+    var validationErr *repositories.ValidationError
+    if !errors.As(err, &validationErr) || validationErr.Fields[0].Field != "vehicle_status" {
+        t.Fatalf("Vehicle should not be created as sold, got %v", err)
+    }
+    // a new vehicle has no status to conflict with, the request is invalid
+    if errors.Is(err, ErrInvalidStatusTransition) {
+        t.Fatal("Initial status should not be a status transition conflict")
+    }
+}
@@ -0,0 +1,98 @@
+package tracing
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const (
+    // ServiceName is the name the spans of this service are reported under
+    ServiceName = "vehicle-svc"
+
+    ExporterOTLP   = "otlp"
+    ExporterStdout = "stdout"
+    ExporterNone   = "none"
+)
+
+var (
+    ErrUnknownExporter = errors.New("unknown tracing exporter")
+)
+
+// Setup installs the global tracer provider and the W3C trace context propagator,
+// the spans are sent to an OTLP collector at endpoint (e.g. http://localhost:4318), written to stdout,
+// or not recorded at all with the none exporter. The returned function flushes the pending spans
+func Setup(ctx context.Context, exporter, endpoint string) (func(context.Context) error, error) {
+    otel.SetTextMapPropagator(
+        propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+    )
+
+    var spanExporter sdktrace.SpanExporter
+    var err error
+    switch exporter {
+    case ExporterNone, "":
+        // the default tracer provider doesn't record anything, but the trace context is still propagated
+        return func(context.Context) error { return nil }, nil
+    case ExporterOTLP:
+        spanExporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+    case ExporterStdout:
+        spanExporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+    default:
+        return nil, fmt.Errorf("%w: %s", ErrUnknownExporter, exporter)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    provider := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(spanExporter),
+        sdktrace.WithResource(
+            resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName)),
+        ),
+    )
+    otel.SetTracerProvider(provider)
+
+    return provider.Shutdown, nil
+}
+
+// AMQPHeaders carries the trace context in the headers of an AMQP message
+type AMQPHeaders amqp.Table
+
+func (h AMQPHeaders) Get(key string) string {
+    value, _ := h[key].(string)
+    return value
+}
+
+func (h AMQPHeaders) Set(key string, value string) {
+    h[key] = value
+}
+
+func (h AMQPHeaders) Keys() []string {
+    keys := make([]string, 0, len(h))
+    for key := range h {
+        keys = append(keys, key)
+    }
+    return keys
+}
+
+// InjectAMQP adds the trace context of ctx to the headers, so the consumer continues the trace
+func InjectAMQP(ctx context.Context, headers amqp.Table) {
+    otel.GetTextMapPropagator().Inject(ctx, AMQPHeaders(headers))
+}
+
+// ExtractAMQP returns ctx with the trace context found in the headers
+func ExtractAMQP(ctx context.Context, headers amqp.Table) context.Context {
+    if headers == nil {
+        return ctx
+    }
+    return otel.GetTextMapPropagator().Extract(ctx, AMQPHeaders(headers))
+}
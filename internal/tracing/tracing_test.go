@@ -0,0 +1,48 @@
+package tracing
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup(t *testing.T) {
+    if _, err := Setup(context.Background(), "zipkin", ""); !errors.Is(err, ErrUnknownExporter) {
+        t.Fatal("Unknown exporter should be rejected")
+    }
+}
+
+func TestAMQPPropagation(t *testing.T) {
+    ctx := context.Background()
+    shutdown, err := Setup(ctx, ExporterStdout, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer func() {
+        if err := shutdown(ctx); err != nil {
+            t.Fatal(err)
+        }
+    }()
+
+    ctx, span := otel.Tracer("tracing_test").Start(ctx, "publish")
+    defer span.End()
+
+    headers := amqp.Table{"x-retry-count": int32(1)}
+    InjectAMQP(ctx, headers)
+
+    if headers["traceparent"] == nil {
+        t.Fatal("Trace context should be added to the headers")
+    }
+
+    extracted := trace.SpanContextFromContext(ExtractAMQP(context.Background(), headers))
+    if extracted.TraceID() != span.SpanContext().TraceID() {
+        t.Fatal("Consumer should continue the publisher's trace")
+    }
+    if extracted.SpanID() != span.SpanContext().SpanID() {
+        t.Fatal("Consumer's parent should be the publisher's span")
+    }
+}
@@ -0,0 +1,165 @@
+package validation
+
+import (
+    "errors"
+    "reflect"
+    "slices"
+    "strconv"
+    "strings"
+
+    "github.com/go-playground/locales"
+    "github.com/go-playground/locales/en"
+    "github.com/go-playground/locales/es"
+    "github.com/go-playground/locales/fr"
+    "github.com/go-playground/locales/ja"
+    "github.com/go-playground/locales/zh"
+    ut "github.com/go-playground/universal-translator"
+    "github.com/go-playground/validator/v10"
+    entranslations "github.com/go-playground/validator/v10/translations/en"
+    estranslations "github.com/go-playground/validator/v10/translations/es"
+    frtranslations "github.com/go-playground/validator/v10/translations/fr"
+    jatranslations "github.com/go-playground/validator/v10/translations/ja"
+    zhtranslations "github.com/go-playground/validator/v10/translations/zh"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+)
+
+// translation is a supported language together with the registration of its validation messages
+type translation struct {
+    locale   locales.Translator
+    register func(validate *validator.Validate, trans ut.Translator) error
+}
+
+// translations are the languages the frontend is available in, English comes first because it is the fallback
+var translations = []translation{
+    {en.New(), entranslations.RegisterDefaultTranslations},
+    {es.New(), estranslations.RegisterDefaultTranslations},
+    {fr.New(), frtranslations.RegisterDefaultTranslations},
+    {ja.New(), jatranslations.RegisterDefaultTranslations},
+    {zh.New(), zhtranslations.RegisterDefaultTranslations},
+}
+
+// Translator turns the errors of the validator into the field errors returned to the client,
+// the messages are translated into the language the client asked for, English is the fallback
+type Translator struct {
+    validate *validator.Validate
+    uni      *ut.UniversalTranslator
+}
+
+// NewTranslator registers the translations on the validator,
+// it also makes the validator report the fields by their JSON name (e.g. vehicle_name instead of VehicleName)
+func NewTranslator(validate *validator.Validate) (*Translator, error) {
+    validate.RegisterTagNameFunc(jsonFieldName)
+
+    uni := ut.New(translations[0].locale)
+    for _, translation := range translations {
+        if err := uni.AddTranslator(translation.locale, true); err != nil {
+            return nil, err
+        }
+        trans, _ := uni.GetTranslator(translation.locale.Locale())
+        if err := translation.register(validate, trans); err != nil {
+            return nil, err
+        }
+    }
+
+    return &Translator{
+        validate: validate,
+        uni:      uni,
+    }, nil
+}
+
+// jsonFieldName is the name of the field in the JSON body, fields without a JSON tag keep their Go name
+func jsonFieldName(field reflect.StructField) string {
+    name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+    switch name {
+    case "-":
+        return ""
+    case "":
+        return field.Name
+    }
+    return name
+}
+
+// Struct validates the struct, the failed rules are returned as a *repositories.ValidationError
+// with the messages in the first of the locales which is supported
+func (t *Translator) Struct(s any, locales ...string) error {
+    err := t.validate.Struct(s)
+    if err == nil {
+        return nil
+    }
+
+    var validationErrs validator.ValidationErrors
+    if !errors.As(err, &validationErrs) {
+        return err
+    }
+    return repositories.NewValidationError(nil, t.FieldErrors(validationErrs, locales...)...)
+}
+
+// FieldErrors translates the validation errors, a field is reported by its JSON name
+func (t *Translator) FieldErrors(errs validator.ValidationErrors, locales ...string) []repositories.FieldError {
+    trans, _ := t.uni.FindTranslator(locales...)
+
+    fields := make([]repositories.FieldError, 0, len(errs))
+    for _, err := range errs {
+        fields = append(
+            fields, repositories.FieldError{
+                Field:   err.Field(),
+                Rule:    err.Tag(),
+                Message: err.Translate(trans),
+            },
+        )
+    }
+    return fields
+}
+
+// AcceptLanguages returns the locales of an Accept-Language header, the most preferred first,
+// e.g. "en;q=0.8, de-CH" is [de_CH de en], a region is followed by its language so "fr-CH" is translated into French.
+// Languages with a quality of 0 are not acceptable and are left out
+func AcceptLanguages(header string) []string {
+    type language struct {
+        locale  string
+        quality float64
+    }
+    var languages []language
+    for _, value := range strings.Split(header, ",") {
+        tag, params, _ := strings.Cut(value, ";")
+        tag = strings.TrimSpace(tag)
+        if tag == "" || tag == "*" {
+            continue
+        }
+        quality := 1.0
+        if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+            parsed, err := strconv.ParseFloat(q, 64)
+            if err != nil {
+                continue
+            }
+            quality = parsed
+        }
+        if quality <= 0 {
+            continue
+        }
+        languages = append(languages, language{locale: strings.ReplaceAll(tag, "-", "_"), quality: quality})
+    }
+    // the languages with the same quality keep the order they were listed in
+    slices.SortStableFunc(
+        languages, func(a, b language) int {
+            switch {
+            case a.quality > b.quality:
+                return -1
+            case a.quality < b.quality:
+                return 1
+            }
+            return 0
+        },
+    )
+
+    var accepted []string
+    for _, language := range languages {
+        base, _, _ := strings.Cut(language.locale, "_")
+        for _, locale := range []string{language.locale, base} {
+            if !slices.Contains(accepted, locale) {
+                accepted = append(accepted, locale)
+            }
+        }
+    }
+    return accepted
+}
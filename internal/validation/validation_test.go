@@ -0,0 +1,99 @@
+package validation
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-vehicle-svc/internal/services"
+)
+
+func TestTranslator_Struct(t *testing.T) {
+    translator, err := NewTranslator(validator.New(validator.WithRequiredStructEnabled()))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = translator.Struct(
+        &services.VehicleRequest{
+            VehicleModel: "Model",
+            Mileage:      100,
+        },
+        "de", "en",
+    )
+
+    var validationErr *repositories.ValidationError
+    if !errors.As(err, &validationErr) {
+        t.Fatalf("Error should be a ValidationError, got %v", err)
+    }
+
+    want := []repositories.FieldError{
+        {Field: "vehicle_name", Rule: "required", Message: "vehicle_name is a required field"},
+        {Field: "vehicle_status", Rule: "required", Message: "vehicle_status is a required field"},
+        {Field: "license_number", Rule: "required", Message: "license_number is a required field"},
+    }
+    if !reflect.DeepEqual(validationErr.Fields, want) {
+        t.Fatalf("Fields should be %v, got %v", want, validationErr.Fields)
+    }
+
+    if err := translator.Struct(
+        &services.VehicleRequest{
+            VehicleName:   "Name",
+            VehicleModel:  "Model",
+            VehicleStatus: "active",
+            Mileage:       100,
+            LicenseNumber: "ABC-123",
+        },
+    ); err != nil {
+        t.Fatalf("Request should be valid, got %v", err)
+    }
+}
+
+func TestTranslator_StructTranslated(t *testing.T) {
+    translator, err := NewTranslator(validator.New(validator.WithRequiredStructEnabled()))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = translator.Struct(
+        &services.VehicleRequest{
+            VehicleName:   "Name",
+            VehicleModel:  "Model",
+            VehicleStatus: "active",
+            Mileage:       100,
+        },
+        AcceptLanguages("fr-CH, en;q=0.8")...,
+    )
+
+    var validationErr *repositories.ValidationError
+    if !errors.As(err, &validationErr) {
+        t.Fatalf("Error should be a ValidationError, got %v", err)
+    }
+
+    want := "license_number est un champ obligatoire"
+    if len(validationErr.Fields) != 1 || validationErr.Fields[0].Message != want {
+        t.Fatalf("Message should be %q, got %v", want, validationErr.Fields)
+    }
+}
+
+func TestAcceptLanguages(t *testing.T) {
+    tests := []struct {
+        header  string
+        locales []string
+    }{
+        {"", nil},
+        {"en", []string{"en"}},
+        {"de-CH, en;q=0.8, *;q=0.5", []string{"de_CH", "de", "en"}},
+        {"en;q=0.5, fr-CH, ja;q=0.8", []string{"fr_CH", "fr", "ja", "en"}},
+        {"es;q=0, en", []string{"en"}},
+    }
+
+    for _, test := range tests {
+        locales := AcceptLanguages(test.header)
+        if !reflect.DeepEqual(locales, test.locales) {
+            t.Fatalf("%q should be %v, got %v", test.header, test.locales, locales)
+        }
+    }
+}
@@ -2,7 +2,8 @@ package main
 
 import (
     "context"
-    "log"
+    "log/slog"
+    "os"
 
     "github.com/go-playground/validator/v10"
     "github.com/yemyoaung/managing-vehicle-tracking-common"
@@ -16,7 +17,8 @@ func main() {
     )
     load, err := common.NewConfigLoaderFromEnvFile[config.EnvConfig](".env", validate)
     if err != nil {
-        log.Fatal("Failed to load config")
+        slog.Error("Failed to load config", slog.Any("error", err))
+        os.Exit(1)
     }
 
     ctx := context.Background()
@@ -28,8 +30,8 @@ func main() {
     err = instance.Shutdown(ctx)
 
     if err != nil {
-        log.Fatal("Shutdown failed:", err)
-        return
+        slog.Error("Shutdown failed", slog.Any("error", err))
+        os.Exit(1)
     }
-    log.Println("App shutdown successfully")
+    slog.Info("App shutdown successfully")
 }